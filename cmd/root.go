@@ -17,8 +17,17 @@ package cmd
 
 import (
 	"carbon/config"
+	"carbon/domain"
+	"carbon/internal/collection"
+	"carbon/internal/content"
+	"carbon/internal/media"
+	"carbon/internal/mirror"
+	"carbon/internal/notification"
+	"carbon/internal/related"
 	"carbon/internal/resource"
 	"carbon/internal/server"
+	"carbon/internal/signing"
+	"carbon/internal/stats"
 	"carbon/internal/token"
 	"carbon/internal/user"
 	"carbon/mysql"
@@ -83,6 +92,10 @@ func rootCmdRun(cmd *cobra.Command, _ []string) {
 	printLogo()
 	log.Debug("running in debug mode")
 
+	if len(config.Get().Secret) < router.MinSecretLength {
+		log.Warnf("the secret is shorter than %d characters, resource files cannot be downloaded", router.MinSecretLength)
+	}
+
 	remote := remote.NewClient(config.Get().Remote.Location, config.Get().Remote.Key)
 
 	database, err := mysql.Initialize()
@@ -109,11 +122,69 @@ func rootCmdRun(cmd *cobra.Command, _ []string) {
 		log.WithField("error", err).Fatal("could not initialize the token manager")
 	}
 
+	mm, err := mirror.NewManager(cmd.Context(), remote)
+	if err != nil {
+		log.WithField("error", err).Fatal("could not initialize the mirror manager")
+	}
+
+	cm, err := content.NewManager(cmd.Context(), database, remote, mm)
+	if err != nil {
+		log.WithField("error", err).Fatal("could not initialize the content manager")
+	}
+	cm.Enqueue(rm.Collection())
+	rm.OnRefresh(func(_ context.Context, _, next []*domain.Resource) {
+		cm.Enqueue(next)
+	})
+
+	nm, err := notification.NewManager(cmd.Context(), database)
+	if err != nil {
+		log.WithField("error", err).Fatal("could not initialize the notification manager")
+	}
+	rm.OnRefresh(nm.ResourcesUpdated)
+	// Versions released while carbon was down were picked up by the first
+	// refresh, before the hook was registered.
+	nm.ResourcesUpdated(cmd.Context(), rm.Restored(), rm.Collection())
+
+	st, err := stats.NewManager(cmd.Context(), database)
+	if err != nil {
+		log.WithField("error", err).Fatal("could not initialize the stats manager")
+	}
+	st.Record(cmd.Context(), nil, rm.Collection())
+	rm.OnRefresh(st.Record)
+
+	med, err := media.NewManager(cmd.Context())
+	if err != nil {
+		log.WithField("error", err).Fatal("could not initialize the media manager")
+	}
+
+	col, err := collection.NewManager(cmd.Context(), database)
+	if err != nil {
+		log.WithField("error", err).Fatal("could not initialize the collection manager")
+	}
+
+	rel, err := related.NewManager(cmd.Context(), database)
+	if err != nil {
+		log.WithField("error", err).Fatal("could not initialize the related resources manager")
+	}
+
+	sig, err := signing.NewManager(cmd.Context())
+	if err != nil {
+		log.WithField("error", err).Fatal("could not initialize the signing manager")
+	}
+
 	managers := router.ManagerGroup{
-		ResourceManager: rm,
-		ServerManager:   sm,
-		UserManager:     um,
-		TokenManager:    tm,
+		ResourceManager:     rm,
+		ServerManager:       sm,
+		UserManager:         um,
+		TokenManager:        tm,
+		MirrorManager:       mm,
+		ContentManager:      cm,
+		NotificationManager: nm,
+		StatsManager:        st,
+		MediaManager:        med,
+		CollectionManager:   col,
+		RelatedManager:      rel,
+		SigningManager:      sig,
 	}
 
 	r := router.NewClient(remote, managers)
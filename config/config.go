@@ -35,6 +35,8 @@ type Configuration struct {
 
 	Key string `yaml:"key"`
 
+	// The key download links are signed with. It must be at least 32
+	// characters long, otherwise no download links are issued.
 	Secret string `yaml:"secret"`
 
 	LogDirectory string `default:"/var/log/carbon" yaml:"log_directory"`
@@ -45,9 +47,17 @@ type Configuration struct {
 	// if the debug flag is passed in command line arguments.
 	Debug bool `default:"true" yaml:"debug"`
 
-	Api    ApiConfiguration    `yaml:"api"`
-	Db     DbConfiguration     `yaml:"db"`
-	Remote RemoteConfiguration `yaml:"remote"`
+	Api       ApiConfiguration       `yaml:"api"`
+	Db        DbConfiguration        `yaml:"db"`
+	Remote    RemoteConfiguration    `yaml:"remote"`
+	Download  DownloadConfiguration  `yaml:"download"`
+	Mirror    MirrorConfiguration    `yaml:"mirror"`
+	Resources ResourcesConfiguration `yaml:"resources"`
+	Content   ContentConfiguration   `yaml:"content"`
+	Stats     StatsConfiguration     `yaml:"stats"`
+	Media     MediaConfiguration     `yaml:"media"`
+	Related   RelatedConfiguration   `yaml:"related"`
+	Signing   SigningConfiguration   `yaml:"signing"`
 }
 
 type RemoteConfiguration struct {
@@ -55,6 +65,89 @@ type RemoteConfiguration struct {
 	Key      string `yaml:"key"`
 }
 
+type DownloadConfiguration struct {
+	// How long, in seconds, a signed download URL remains valid after it
+	// has been issued to a user.
+	UrlTtl time.Duration `default:"300" yaml:"url_ttl"`
+}
+
+type ResourcesConfiguration struct {
+	// The ID of the XenForo custom field in which authors list the
+	// resources theirs depends on, either by ID or by URL.
+	DependencyField string `default:"dependencies" yaml:"dependency_field"`
+
+	// The ID of the XenForo custom field in which authors list the game
+	// versions their resource works with, such as "2022.12" or
+	// "2022.04 - 2022.12".
+	GameVersionField string `default:"game_versions" yaml:"game_version_field"`
+}
+
+type ContentConfiguration struct {
+	// Download new resource files in the background and index the game
+	// content found inside of them.
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// The largest archive, in megabytes, that will be downloaded for
+	// indexing. Anything larger is skipped.
+	MaxArchiveSize int64 `default:"512" yaml:"max_archive_size"`
+}
+
+type MediaConfiguration struct {
+	// The maximum size of the resized image cache in megabytes. The least
+	// recently served images are evicted once it is exceeded.
+	MaxSize int64 `default:"512" yaml:"max_size"`
+
+	// Hosts, besides the forum itself, that images may be fetched from,
+	// such as gravatar.com for user avatars.
+	AllowedHosts []string `yaml:"allowed_hosts"`
+}
+
+type StatsConfiguration struct {
+	// How often, in minutes, the trending and popular rankings are computed
+	// again from the counters recorded on every resource refresh.
+	Interval time.Duration `default:"15" yaml:"interval"`
+}
+
+type RelatedConfiguration struct {
+	// How often, in minutes, the "also downloaded" recommendations are
+	// computed again from the resources users fetched.
+	Interval time.Duration `default:"360" yaml:"interval"`
+
+	// How long, in days, a fetch counts towards the recommendations.
+	Retention int `default:"90" yaml:"retention"`
+}
+
+type SigningConfiguration struct {
+	// Identifier of the current key, sent along with every signature so
+	// that clients know which public key to verify it with.
+	KeyId string `yaml:"key_id"`
+
+	// Path to the PEM encoded (PKCS #8) Ed25519 private key that manifest
+	// responses are signed with. Responses are not signed when it is empty.
+	PrivateKeyFile string `yaml:"private_key_file"`
+
+	// Public keys that no longer sign responses but are still published so
+	// that clients can verify what was signed before a rotation.
+	RetiredKeys []RetiredSigningKey `yaml:"retired_keys"`
+}
+
+type RetiredSigningKey struct {
+	KeyId string `yaml:"key_id"`
+
+	// The base64 encoded Ed25519 public key.
+	PublicKey string `yaml:"public_key"`
+}
+
+type MirrorConfiguration struct {
+	// Keep a local copy of resource files under the root directory and
+	// serve downloads from it instead of XenForo.
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// The maximum size of the mirror in megabytes. The least recently
+	// downloaded files are evicted once it is exceeded.
+	MaxSize int64 `default:"10240" yaml:"max_size"`
+}
+
 type ApiConfiguration struct {
 	Host string `default:"0.0.0.0" yaml:"host"`
 	Port int    `default:"8080" yaml:"port"`
@@ -68,6 +161,12 @@ type ApiConfiguration struct {
 	ReadTimeout  time.Duration `default:"15" yaml:"read_timeout"`
 	WriteTimeout time.Duration `default:"15" yaml:"write_timeout"`
 	IdleTimeout  time.Duration `default:"60" yaml:"idle_timeout"`
+
+	// The Cache-Control header sent by the catalog endpoints, keyed by the
+	// route name (resources, resource-categories, feeds). Routes
+	// that are not listed fall back to "no-cache" which makes clients
+	// revalidate with a conditional request every time.
+	CacheControl map[string]string `yaml:"cache_control"`
 }
 
 type DbConfiguration struct {
@@ -0,0 +1,53 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package domain
+
+import "time"
+
+// Collection is a named, ordered list of resources put together by a user,
+// such as the vehicle set of a convoy event. Private collections are only
+// visible to their owner.
+type Collection struct {
+	ID          uint             `gorm:"primaryKey" json:"collection_id"`
+	UserId      int              `gorm:"not null;index" json:"user_id"`
+	Name        string           `gorm:"size:100;not null" json:"name"`
+	Description string           `gorm:"type:text" json:"description"`
+	Public      bool             `gorm:"not null;default:false;index" json:"public"`
+	Items       []CollectionItem `gorm:"constraint:OnDelete:CASCADE" json:"items"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// CollectionItem is a resource in a collection. Items are listed by their
+// position.
+type CollectionItem struct {
+	ID           uint `gorm:"primaryKey" json:"-"`
+	CollectionId uint `gorm:"not null;uniqueIndex:idx_collection_item_resource" json:"-"`
+	ResourceId   int  `gorm:"not null;uniqueIndex:idx_collection_item_resource" json:"resource_id"`
+	Position     int  `gorm:"not null" json:"position"`
+
+	Resource *Resource `gorm:"-" json:"resource,omitempty"`
+}
+
+// CollectionManifest lists what has to be downloaded to install every
+// resource of a collection. Resources that are no longer available, or
+// could not be loaded, are listed as missing.
+type CollectionManifest struct {
+	CollectionId uint            `json:"collection_id"`
+	Name         string          `json:"name"`
+	Entries      []ManifestEntry `json:"entries"`
+	Missing      []int           `json:"missing"`
+}
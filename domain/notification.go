@@ -0,0 +1,47 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package domain
+
+import "time"
+
+const NotificationResourceUpdate = "resource_update"
+
+// Follow subscribes a user to the updates of a resource.
+type Follow struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	UserId     int       `gorm:"not null;uniqueIndex:idx_follow_user_resource" json:"user_id"`
+	ResourceId int       `gorm:"not null;uniqueIndex:idx_follow_user_resource;index" json:"resource_id"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	Resource *Resource `gorm:"-" json:"resource,omitempty"`
+}
+
+// Notification tells a user that something happened to a resource they
+// follow. There is at most one unread notification per user and resource,
+// later updates are folded into it.
+type Notification struct {
+	ID              uint       `gorm:"primaryKey" json:"notification_id"`
+	UserId          int        `gorm:"not null;index:idx_notification_user_read" json:"user_id"`
+	ResourceId      int        `gorm:"not null;index" json:"resource_id"`
+	Type            string     `gorm:"size:32;not null" json:"type"`
+	Title           string     `gorm:"size:255" json:"title"`
+	Version         string     `gorm:"size:255" json:"version"`
+	PreviousVersion string     `gorm:"size:255" json:"previous_version,omitempty"`
+	Read            bool       `gorm:"not null;default:false;index:idx_notification_user_read" json:"read"`
+	ReadAt          *time.Time `json:"read_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
@@ -15,7 +15,10 @@
 
 package domain
 
-import "strconv"
+import (
+	"strconv"
+	"time"
+)
 
 type Resource struct {
 	ResourceId         int    `json:"resource_id"`
@@ -23,6 +26,8 @@ type Resource struct {
 	ResourceState      string `json:"resource_state"`
 	ResourceType       string `json:"resource_type"`
 	Title              string `json:"title"`
+	UserId             int    `json:"user_id"`
+	Username           string `json:"username"`
 	TagLine            string `json:"tag_line"`
 	UpdateCount        int    `json:"update_count"`
 	ExternalUrl        string `json:"external_url,omitempty"`
@@ -44,12 +49,33 @@ type Resource struct {
 	CustomFields   interface{}    `json:"custom_fields,omitempty"`
 	CanDownload    bool           `json:"can_download"`
 	CurrentFiles   []ResourceFile `json:"current_files"`
+	Dependencies   []int          `json:"dependencies,omitempty"`
+
+	DescriptionUpdateId int                `json:"description_update_id,omitempty"`
+	GameVersions        []GameVersionRange `json:"game_versions,omitempty"`
+}
+
+// GameVersionRange is a range of game versions a resource works with, bounds
+// included. Either bound may be empty, in which case the range is open on that
+// side. A single version has the same minimum and maximum.
+type GameVersionRange struct {
+	Min string `json:"min,omitempty"`
+	Max string `json:"max,omitempty"`
 }
 
 func (r *Resource) ID() string {
 	return strconv.Itoa(r.ResourceId)
 }
 
+// ResourceDependencies is the resolved set of resources a resource depends
+// on, directly or not, in the order they should be installed.
+type ResourceDependencies struct {
+	Resources []*Resource `json:"resources"`
+	Missing   []int       `json:"missing"`
+	Deleted   []int       `json:"deleted"`
+	Cycles    [][]int     `json:"cycles"`
+}
+
 type ResourceReview struct {
 	Message           string `json:"message,omitempty"`
 	ResourceRatingId  uint   `json:"resource_rating_id"`
@@ -58,7 +84,16 @@ type ResourceReview struct {
 	RatingState       string `json:"rating_state"`
 	ResourceVersionId uint   `json:"resource_version_id"`
 	ResourceId        uint   `json:"resource_id"`
-	//User              user.User `json:"User"`
+	UserId            int    `json:"user_id"`
+	User              *User  `json:"user,omitempty"`
+}
+
+// ReviewSummary is the distribution of star ratings across the reviews of a
+// resource.
+type ReviewSummary struct {
+	Count     int          `json:"count"`
+	Average   float64      `json:"average"`
+	Histogram map[uint]int `json:"histogram"`
 }
 
 type ResourceCategory struct {
@@ -75,6 +110,14 @@ func (rc *ResourceCategory) ID() string {
 	return strconv.Itoa(rc.ResourceCategoryId)
 }
 
+// ResourceCategoryNode is a category placed within the category hierarchy.
+// TotalResourceCount includes the resources of every descendant category.
+type ResourceCategoryNode struct {
+	ResourceCategory
+	TotalResourceCount uint                    `json:"total_resource_count"`
+	Children           []*ResourceCategoryNode `json:"children"`
+}
+
 type ResourceVersion struct {
 	ResourceVersionId uint           `json:"resource_version_id"`
 	ResourceId        uint           `json:"resource_id"`
@@ -90,13 +133,127 @@ type ResourceUpdate struct {
 	Message          string `json:"message"`
 	Title            string `json:"title"`
 	ViewUrl          string `json:"view_url"`
-	PostDate         uint   `json:"post_Date"`
+	PostDate         uint   `json:"post_date"`
 	AttachCount      int    `json:"attach_count"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment is a file attached to a post on the forum, such as a screenshot
+// in the description of a resource.
+type Attachment struct {
+	AttachmentId uint   `json:"attachment_id"`
+	Filename     string `json:"filename"`
+	FileSize     uint   `json:"file_size"`
+	Width        uint   `json:"width"`
+	Height       uint   `json:"height"`
+	ThumbnailUrl string `json:"thumbnail_url"`
+	DirectUrl    string `json:"direct_url"`
+	AttachDate   uint   `json:"attach_date"`
+	IsVideo      bool   `json:"is_video"`
+}
+
+// GalleryImage is a screenshot of a resource along with a thumbnail of it.
+type GalleryImage struct {
+	AttachmentId     uint   `json:"attachment_id"`
+	ResourceUpdateId int    `json:"resource_update_id"`
+	Filename         string `json:"filename"`
+	Width            uint   `json:"width"`
+	Height           uint   `json:"height"`
+	ThumbnailUrl     string `json:"thumbnail_url"`
+	Url              string `json:"url"`
+}
+
+const (
+	ChangelogRelease = "release"
+	ChangelogVersion = "version"
+	ChangelogUpdate  = "update"
+)
+
+// ChangelogEntry is an event in the history of a resource. A release is a
+// version published together with an update post describing it.
+type ChangelogEntry struct {
+	Type              string         `json:"type"`
+	Date              uint           `json:"date"`
+	ResourceVersionId uint           `json:"resource_version_id,omitempty"`
+	VersionString     string         `json:"version_string,omitempty"`
+	Files             []ResourceFile `json:"files,omitempty"`
+	Diff              *FileDiff      `json:"diff,omitempty"`
+	ResourceUpdateId  int            `json:"resource_update_id,omitempty"`
+	Title             string         `json:"title,omitempty"`
+	Message           string         `json:"message,omitempty"`
+}
+
+// FileDiff lists how the files of a version differ from the version before.
+// Files are matched by name.
+type FileDiff struct {
+	Added   []ResourceFile `json:"added"`
+	Removed []ResourceFile `json:"removed"`
+	Changed []FileChange   `json:"changed"`
+}
+
+type FileChange struct {
+	ResourceFile
+	PreviousSize uint `json:"previous_size"`
 }
 
 type ResourceFile struct {
-	Id       uint   `json:"id"`
-	FileName string `json:"filename"`
-	Size     uint   `json:"size"`
-	// DownloadUrl string `json:"download_url"`
+	Id          uint   `json:"id"`
+	FileName    string `json:"filename"`
+	Size        uint   `json:"size"`
+	DownloadUrl string `json:"download_url,omitempty"`
+}
+
+const (
+	ResourceAdded   = "added"
+	ResourceUpdated = "updated"
+	ResourceDeleted = "deleted"
+)
+
+// ResourceChange describes how a resource changed since a change feed cursor.
+// Resource holds its current state and is omitted for deleted resources.
+type ResourceChange struct {
+	Type       string    `json:"type"`
+	ResourceId int       `json:"resource_id"`
+	Resource   *Resource `json:"resource,omitempty"`
+}
+
+// ManifestFile is a file that has to be downloaded to install a resource.
+// The SHA-256 is only known for files that have been mirrored.
+type ManifestFile struct {
+	ResourceFile
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// ManifestEntry lists everything needed to install a version of a resource.
+type ManifestEntry struct {
+	ResourceId        int            `json:"resource_id"`
+	ResourceVersionId uint           `json:"resource_version_id,omitempty"`
+	Title             string         `json:"title"`
+	Version           string         `json:"version"`
+	Files             []ManifestFile `json:"files"`
+}
+
+// ContentEntry is a piece of game content, such as a vehicle or a terrain,
+// found inside the archive of a resource file.
+type ContentEntry struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	Guid       string    `gorm:"size:64;index" json:"guid"`
+	Name       string    `gorm:"size:255" json:"name"`
+	Type       string    `gorm:"size:32" json:"type"`
+	CategoryId int       `json:"category_id,omitempty"`
+	ResourceId int       `gorm:"index" json:"resource_id"`
+	FileId     uint      `gorm:"index" json:"file_id"`
+	FileName   string    `gorm:"size:255" json:"filename"`
+	Path       string    `gorm:"size:1024" json:"path"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// IndexedFile records that a resource file has been indexed, so that it is
+// only downloaded and scanned once.
+type IndexedFile struct {
+	FileId     uint      `gorm:"primaryKey;autoIncrement:false" json:"file_id"`
+	ResourceId int       `gorm:"index" json:"resource_id"`
+	Error      string    `gorm:"size:1024" json:"error,omitempty"`
+	IndexedAt  time.Time `json:"indexed_at"`
 }
@@ -0,0 +1,31 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package domain
+
+const (
+	SigningKeyCurrent = "current"
+	SigningKeyRetired = "retired"
+)
+
+// SigningKey is a public key clients use to verify signed responses. Retired
+// keys no longer sign anything but are still published so that responses
+// signed before a rotation can be verified.
+type SigningKey struct {
+	KeyId     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+	Status    string `json:"status"`
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package domain
+
+import "time"
+
+// ResourceStat is a snapshot of the lifetime counters of a resource. A new
+// snapshot is only recorded when one of the counters has changed.
+type ResourceStat struct {
+	ID            uint      `gorm:"primaryKey" json:"-"`
+	ResourceId    int       `gorm:"not null;index:idx_resource_stat_resource_created" json:"resource_id"`
+	DownloadCount uint      `json:"download_count"`
+	ViewCount     uint      `json:"view_count"`
+	CreatedAt     time.Time `gorm:"index;index:idx_resource_stat_resource_created" json:"created_at"`
+}
+
+// ResourceScore ranks a resource by its activity over a window of time.
+type ResourceScore struct {
+	ResourceId int       `json:"resource_id"`
+	Score      float64   `json:"score"`
+	Downloads  uint      `json:"downloads"`
+	Views      uint      `json:"views"`
+	Resource   *Resource `json:"resource,omitempty"`
+}
+
+const (
+	RelatedAlsoDownloaded = "also_downloaded"
+	RelatedSameAuthor     = "same_author"
+	RelatedSameCategory   = "same_category"
+)
+
+// ResourceFetch records that a user looked at the versions of a resource,
+// which is what clients do right before downloading one. Only the last fetch
+// per user and resource is kept.
+type ResourceFetch struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	UserId     int       `gorm:"not null;uniqueIndex:idx_resource_fetch_user_resource" json:"user_id"`
+	ResourceId int       `gorm:"not null;uniqueIndex:idx_resource_fetch_user_resource" json:"resource_id"`
+	FetchedAt  time.Time `gorm:"not null;index" json:"fetched_at"`
+}
+
+// RelatedResource is a resource recommended alongside another one. Reason
+// tells why it was picked, the score is only meaningful for resources that
+// were also downloaded by the same users.
+type RelatedResource struct {
+	ResourceId int       `json:"resource_id"`
+	Reason     string    `json:"reason"`
+	Score      float64   `json:"score"`
+	Resource   *Resource `json:"resource,omitempty"`
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package bbcode renders the BBCode used by XenForo posts into formats that
+// clients can display.
+package bbcode
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"strings"
+	"sync"
+)
+
+type Format string
+
+const (
+	// BBCode leaves the source untouched.
+	BBCode   Format = "bbcode"
+	Html     Format = "html"
+	Markdown Format = "markdown"
+	Text     Format = "text"
+)
+
+// ParseFormat returns the format with the given name. An empty name is the
+// raw BBCode.
+func ParseFormat(s string) (Format, bool) {
+	switch f := Format(strings.ToLower(s)); f {
+	case "":
+		return BBCode, true
+	case BBCode, Html, Markdown, Text:
+		return f, true
+	}
+	return "", false
+}
+
+// cacheSize is the number of rendered documents kept in memory.
+const cacheSize = 4096
+
+var cache = newLru(cacheSize)
+
+// Render converts the BBCode source to the format. The HTML output only ever
+// contains the elements produced by the whitelisted tags, and all text is
+// escaped. Rendered documents are cached.
+func Render(src string, f Format) string {
+	if f == BBCode || src == "" {
+		return src
+	}
+
+	key := sha256.Sum256([]byte(string(f) + "\x00" + src))
+	if v, ok := cache.get(key); ok {
+		return v
+	}
+
+	// Invalid UTF-8 never makes it into the output.
+	root := parse(strings.ToValidUTF8(src, "\uFFFD"))
+	var out string
+	switch f {
+	case Html:
+		var r htmlRenderer
+		r.render(root)
+		out = r.b.String()
+	case Markdown:
+		var r markdownRenderer
+		r.render(root)
+		out = r.b.String()
+	default:
+		var r textRenderer
+		r.render(root)
+		out = r.b.String()
+	}
+	out = strings.TrimSpace(out)
+
+	cache.put(key, out)
+	return out
+}
+
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[[32]byte]*list.Element
+}
+
+type lruEntry struct {
+	key   [32]byte
+	value string
+}
+
+func newLru(size int) *lru {
+	return &lru{size: size, order: list.New(), items: make(map[[32]byte]*list.Element)}
+}
+
+func (c *lru) get(key [32]byte) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+func (c *lru) put(key [32]byte, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		e.Value.(*lruEntry).value = value
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package bbcode
+
+import "strings"
+
+// maxDepth is how deeply tags can be nested. Anything deeper is left as text
+// so that hostile input cannot blow up the renderers.
+const maxDepth = 32
+
+type tag struct {
+	// raw tags keep their content as is, without parsing nested tags.
+	raw bool
+	// block tags swallow the line break that follows them, as XenForo
+	// does, since the element already starts a new line.
+	block bool
+}
+
+// tags is the whitelist of tags that are understood. Anything else is kept
+// as literal text.
+var tags = map[string]tag{
+	"b":        {},
+	"i":        {},
+	"u":        {},
+	"s":        {},
+	"sub":      {},
+	"sup":      {},
+	"color":    {},
+	"size":     {},
+	"font":     {},
+	"url":      {},
+	"email":    {},
+	"user":     {},
+	"img":      {raw: true},
+	"media":    {raw: true},
+	"attach":   {raw: true},
+	"icode":    {raw: true},
+	"plain":    {raw: true},
+	"code":     {raw: true, block: true},
+	"php":      {raw: true, block: true},
+	"html":     {raw: true, block: true},
+	"quote":    {block: true},
+	"spoiler":  {block: true},
+	"ispoiler": {},
+	"list":     {block: true},
+	"*":        {block: true},
+	"left":     {block: true},
+	"center":   {block: true},
+	"right":    {block: true},
+	"indent":   {block: true},
+	"heading":  {block: true},
+	"table":    {block: true},
+	"tr":       {block: true},
+	"th":       {},
+	"td":       {},
+}
+
+type node struct {
+	name     string // empty for text
+	arg      string
+	text     string
+	children []*node
+}
+
+// parse turns BBCode into a tree. It never fails: tags that are unknown,
+// unbalanced or too deeply nested are kept as text.
+func parse(src string) *node {
+	root := &node{name: "root"}
+	stack := []*node{root}
+	top := func() *node { return stack[len(stack)-1] }
+
+	var text strings.Builder
+	// trimBreak drops the line break right before the end of a block, which
+	// would otherwise show up as an empty line inside the element.
+	trimBreak := func() {
+		t := strings.TrimSuffix(strings.TrimSuffix(text.String(), "\n"), "\r")
+		text.Reset()
+		text.WriteString(t)
+	}
+	flush := func() {
+		if text.Len() > 0 {
+			n := top()
+			n.children = append(n.children, &node{text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(src); {
+		if src[i] != '[' {
+			j := strings.IndexByte(src[i:], '[')
+			if j < 0 {
+				j = len(src) - i
+			}
+			text.WriteString(src[i : i+j])
+			i += j
+			continue
+		}
+
+		name, arg, closing, end, ok := scanTag(src, i)
+		t, known := tags[name]
+		if !ok || !known {
+			text.WriteByte('[')
+			i++
+			continue
+		}
+
+		if closing {
+			at := -1
+			for k := len(stack) - 1; k > 0; k-- {
+				if stack[k].name == name {
+					at = k
+					break
+				}
+			}
+			if at < 0 {
+				text.WriteString(src[i:end])
+				i = end
+				continue
+			}
+			if t.block {
+				trimBreak()
+			}
+			flush()
+			stack = stack[:at]
+			i = skipBreak(src, end, t.block)
+			continue
+		}
+
+		// List items are closed by the next item or the end of the list,
+		// and only mean something within a list.
+		if name == "*" {
+			if !inside(stack, "list") {
+				text.WriteString(src[i:end])
+				i = end
+				continue
+			}
+			trimBreak()
+			flush()
+			for top().name != "list" {
+				stack = stack[:len(stack)-1]
+			}
+		}
+
+		if len(stack) > maxDepth {
+			text.WriteString(src[i:end])
+			i = end
+			continue
+		}
+
+		flush()
+		n := &node{name: name, arg: arg}
+		top().children = append(top().children, n)
+
+		if t.raw {
+			content, next := scanRaw(src, end, name)
+			n.children = []*node{{text: content}}
+			i = skipBreak(src, next, t.block)
+			continue
+		}
+
+		stack = append(stack, n)
+		i = skipBreak(src, end, t.block)
+	}
+	flush()
+	return root
+}
+
+// scanTag reads the tag starting at src[i], which must be a '['. The returned
+// name is lower cased and end is the index right after the closing ']'.
+func scanTag(src string, i int) (name, arg string, closing bool, end int, ok bool) {
+	j := i + 1
+	if j < len(src) && src[j] == '/' {
+		closing = true
+		j++
+	}
+	start := j
+	for j < len(src) && (isAlnum(src[j]) || src[j] == '*') {
+		j++
+	}
+	if j == start || j-start > 16 {
+		return "", "", false, 0, false
+	}
+	name = strings.ToLower(src[start:j])
+
+	if j < len(src) && !closing && (src[j] == '=' || src[j] == ' ') {
+		k := strings.IndexByte(src[j:], ']')
+		if k < 0 || strings.IndexByte(src[j:j+k], '\n') >= 0 {
+			return "", "", false, 0, false
+		}
+		if src[j] == '=' {
+			arg = strings.Trim(src[j+1:j+k], `"'`)
+		}
+		j += k
+	}
+	if j >= len(src) || src[j] != ']' {
+		return "", "", false, 0, false
+	}
+	return name, arg, closing, j + 1, true
+}
+
+// scanRaw returns everything up to the closing tag of name, and the index
+// right after it. Unclosed raw tags run to the end of the input.
+func scanRaw(src string, i int, name string) (string, int) {
+	closing := "[/" + name + "]"
+	k := indexFold(src[i:], closing)
+	if k < 0 {
+		return src[i:], len(src)
+	}
+	return src[i : i+k], i + k + len(closing)
+}
+
+// indexFold returns the index of the first case insensitive match of the
+// ASCII substr in s. Only ASCII letters are folded, so that the index always
+// refers to the original bytes whatever else s contains.
+func indexFold(s, substr string) int {
+	for k := 0; k+len(substr) <= len(s); k++ {
+		if equalFoldAscii(s[k:k+len(substr)], substr) {
+			return k
+		}
+	}
+	return -1
+}
+
+func equalFoldAscii(a, b string) bool {
+	for i := 0; i < len(a); i++ {
+		x, y := a[i], b[i]
+		if 'A' <= x && x <= 'Z' {
+			x += 'a' - 'A'
+		}
+		if 'A' <= y && y <= 'Z' {
+			y += 'a' - 'A'
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+func skipBreak(src string, i int, block bool) int {
+	if !block {
+		return i
+	}
+	if strings.HasPrefix(src[i:], "\r\n") {
+		return i + 2
+	}
+	if strings.HasPrefix(src[i:], "\n") {
+		return i + 1
+	}
+	return i
+}
+
+func inside(stack []*node, name string) bool {
+	for _, n := range stack {
+		if n.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isAlnum(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
+
+// textContent returns the text of the node and its children, without any
+// markup.
+func textContent(n *node) string {
+	if n.name == "" {
+		return n.text
+	}
+	var b strings.Builder
+	for _, c := range n.children {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,42 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package bbcode
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRawTagsWithNonAsciiContent(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"[code]İ[/code]", "<pre><code>İ</code></pre>"},
+		{"[CODE]\xff[/code]", "<pre><code>�</code></pre>"},
+		{"[img]İ[/img]x", "x"},
+	}
+	for _, tt := range tests {
+		got := Render(tt.src, Html)
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("Render(%q) = %q, want it to contain %q", tt.src, got, tt.want)
+		}
+		if !utf8.ValidString(got) || strings.Contains(got, "]") {
+			t.Errorf("Render(%q) = %q, leaks invalid UTF-8 or markup", tt.src, got)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package bbcode
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var colorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]{1,20}|rgba?\([0-9\s,.%]{1,40}\))$`)
+
+// safeUrl returns the URL if it is absolute and uses one of the allowed
+// schemes. Anything else, such as javascript: links, is rejected.
+func safeUrl(raw string, schemes ...string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Host == "" && u.Scheme != "mailto") {
+		return "", false
+	}
+	for _, s := range schemes {
+		if strings.EqualFold(u.Scheme, s) {
+			return u.String(), true
+		}
+	}
+	return "", false
+}
+
+// linkTarget returns where a url or email tag points to. The address is either
+// the argument of the tag or its content.
+func linkTarget(n *node) (string, bool) {
+	target := n.arg
+	if target == "" {
+		target = textContent(n)
+	}
+	if n.name == "email" {
+		return safeUrl("mailto:"+strings.TrimPrefix(target, "mailto:"), "mailto")
+	}
+	return safeUrl(target, "http", "https")
+}
+
+// mediaUrl returns the page of embedded media. Only the sites whose URLs can
+// be rebuilt from the media ID are supported.
+func mediaUrl(n *node) (string, bool) {
+	id := url.PathEscape(strings.TrimSpace(textContent(n)))
+	switch strings.ToLower(n.arg) {
+	case "youtube":
+		return "https://www.youtube.com/watch?v=" + id, true
+	case "vimeo":
+		return "https://vimeo.com/" + id, true
+	}
+	return "", false
+}
+
+// quoteAuthor extracts the name from a quote argument such as
+// "name, post: 123, member: 4".
+func quoteAuthor(arg string) string {
+	name, _, _ := strings.Cut(arg, ",")
+	return strings.TrimSpace(name)
+}
+
+func headingLevel(arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > 3 {
+		return 1
+	}
+	return n
+}
+
+// markdownUrl escapes the characters that would end a link destination.
+func markdownUrl(u string) string {
+	return strings.NewReplacer("<", "%3C", ">", "%3E", "\n", "").Replace(u)
+}
+
+type htmlRenderer struct {
+	b strings.Builder
+}
+
+func (r *htmlRenderer) children(n *node) {
+	for _, c := range n.children {
+		r.render(c)
+	}
+}
+
+func (r *htmlRenderer) wrap(open, close string, n *node) {
+	r.b.WriteString(open)
+	r.children(n)
+	r.b.WriteString(close)
+}
+
+func (r *htmlRenderer) render(n *node) {
+	switch n.name {
+	case "":
+		r.b.WriteString(strings.ReplaceAll(html.EscapeString(n.text), "\n", "<br>\n"))
+	case "root", "font", "user":
+		r.children(n)
+	case "b":
+		r.wrap("<strong>", "</strong>", n)
+	case "i":
+		r.wrap("<em>", "</em>", n)
+	case "u", "s", "sub", "sup":
+		r.wrap("<"+n.name+">", "</"+n.name+">", n)
+	case "color":
+		if !colorPattern.MatchString(n.arg) {
+			r.children(n)
+			return
+		}
+		r.wrap(`<span style="color: `+html.EscapeString(n.arg)+`">`, "</span>", n)
+	case "size":
+		size, err := strconv.Atoi(n.arg)
+		if err != nil || size < 1 || size > 7 {
+			r.children(n)
+			return
+		}
+		r.wrap(fmt.Sprintf(`<span style="font-size: %d%%">`, []int{63, 82, 100, 113, 150, 200, 300}[size-1]), "</span>", n)
+	case "url", "email":
+		href, ok := linkTarget(n)
+		if !ok {
+			r.children(n)
+			return
+		}
+		r.wrap(`<a href="`+html.EscapeString(href)+`" rel="nofollow ugc noopener">`, "</a>", n)
+	case "img":
+		if src, ok := safeUrl(textContent(n), "http", "https"); ok {
+			r.b.WriteString(`<img src="` + html.EscapeString(src) + `" alt="" loading="lazy">`)
+		}
+	case "media":
+		if href, ok := mediaUrl(n); ok {
+			r.b.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow ugc noopener">` + html.EscapeString(href) + `</a>`)
+		}
+	case "attach":
+		// Attachments are listed in the gallery instead.
+	case "icode":
+		r.b.WriteString("<code>" + html.EscapeString(textContent(n)) + "</code>")
+	case "plain":
+		r.b.WriteString(html.EscapeString(textContent(n)))
+	case "code", "php", "html":
+		r.b.WriteString("<pre><code>" + html.EscapeString(textContent(n)) + "</code></pre>\n")
+	case "quote":
+		r.b.WriteString("<blockquote>")
+		if author := quoteAuthor(n.arg); author != "" {
+			r.b.WriteString("<cite>" + html.EscapeString(author) + "</cite>")
+		}
+		r.children(n)
+		r.b.WriteString("</blockquote>\n")
+	case "spoiler":
+		title := "Spoiler"
+		if n.arg != "" {
+			title += ": " + n.arg
+		}
+		r.wrap("<details><summary>"+html.EscapeString(title)+"</summary>", "</details>\n", n)
+	case "ispoiler":
+		r.wrap(`<span class="spoiler">`, "</span>", n)
+	case "list":
+		if n.arg == "" {
+			r.wrap("<ul>", "</ul>\n", n)
+		} else {
+			r.wrap("<ol>", "</ol>\n", n)
+		}
+	case "*":
+		r.wrap("<li>", "</li>", n)
+	case "left", "center", "right":
+		r.wrap(`<div style="text-align: `+n.name+`">`, "</div>\n", n)
+	case "indent":
+		r.wrap(`<div style="margin-left: 2em">`, "</div>\n", n)
+	case "heading":
+		h := strconv.Itoa(headingLevel(n.arg) + 1)
+		r.wrap("<h"+h+">", "</h"+h+">\n", n)
+	case "table":
+		r.wrap("<table>", "</table>\n", n)
+	case "tr":
+		r.wrap("<tr>", "</tr>", n)
+	case "th", "td":
+		r.wrap("<"+n.name+">", "</"+n.name+">", n)
+	default:
+		r.children(n)
+	}
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "~", `\~`, "|", `\|`,
+)
+
+type markdownRenderer struct {
+	b strings.Builder
+}
+
+func (r *markdownRenderer) children(n *node) {
+	for _, c := range n.children {
+		r.render(c)
+	}
+}
+
+func (r *markdownRenderer) wrap(open, close string, n *node) {
+	r.b.WriteString(open)
+	r.children(n)
+	r.b.WriteString(close)
+}
+
+// nested renders the children of the node on their own so that the result
+// can be transformed, such as prefixing every line of a quote.
+func (r *markdownRenderer) nested(n *node) string {
+	var sub markdownRenderer
+	sub.children(n)
+	return sub.b.String()
+}
+
+func (r *markdownRenderer) block() {
+	s := r.b.String()
+	if len(s) > 0 && !strings.HasSuffix(s, "\n") {
+		r.b.WriteString("\n")
+	}
+}
+
+func (r *markdownRenderer) render(n *node) {
+	switch n.name {
+	case "":
+		r.b.WriteString(markdownEscaper.Replace(n.text))
+	case "root", "font", "user", "color", "size", "u", "sub", "sup", "left", "center", "right", "ispoiler":
+		r.children(n)
+	case "b":
+		r.wrap("**", "**", n)
+	case "i":
+		r.wrap("*", "*", n)
+	case "s":
+		r.wrap("~~", "~~", n)
+	case "url", "email":
+		href, ok := linkTarget(n)
+		if !ok {
+			r.children(n)
+			return
+		}
+		r.wrap("[", "](<"+markdownUrl(href)+">)", n)
+	case "img":
+		if src, ok := safeUrl(textContent(n), "http", "https"); ok {
+			r.b.WriteString("![](<" + markdownUrl(src) + ">)")
+		}
+	case "media":
+		if href, ok := mediaUrl(n); ok {
+			r.b.WriteString("<" + href + ">")
+		}
+	case "attach":
+	case "icode":
+		r.b.WriteString("`` " + textContent(n) + " ``")
+	case "plain":
+		r.b.WriteString(markdownEscaper.Replace(textContent(n)))
+	case "code", "php", "html":
+		r.block()
+		r.b.WriteString("```\n" + strings.TrimRight(strings.ReplaceAll(textContent(n), "```", "` ` `"), "\n") + "\n```\n")
+	case "quote", "spoiler", "indent":
+		r.block()
+		if author := quoteAuthor(n.arg); n.name == "quote" && author != "" {
+			r.b.WriteString("> **" + markdownEscaper.Replace(author) + "**\n>\n")
+		}
+		for _, line := range strings.Split(strings.TrimRight(r.nested(n), "\n"), "\n") {
+			r.b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+		}
+	case "list":
+		r.block()
+		for i, c := range n.children {
+			if c.name != "*" {
+				continue
+			}
+			marker := "- "
+			if n.arg != "" {
+				marker = strconv.Itoa(i+1) + ". "
+			}
+			lines := strings.Split(strings.TrimRight(r.nested(c), "\n"), "\n")
+			r.b.WriteString(marker + lines[0] + "\n")
+			for _, line := range lines[1:] {
+				r.b.WriteString(strings.Repeat(" ", len(marker)) + line + "\n")
+			}
+		}
+	case "heading":
+		r.block()
+		r.b.WriteString(strings.Repeat("#", headingLevel(n.arg)+1) + " " + strings.ReplaceAll(r.nested(n), "\n", " ") + "\n")
+	case "table":
+		r.block()
+		rows := 0
+		for _, row := range n.children {
+			if row.name != "tr" {
+				continue
+			}
+			var cells []string
+			for _, cell := range row.children {
+				if cell.name == "th" || cell.name == "td" {
+					cells = append(cells, strings.ReplaceAll(r.nested(cell), "\n", " "))
+				}
+			}
+			r.b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+			if rows == 0 {
+				r.b.WriteString(strings.Repeat("| --- ", len(cells)) + "|\n")
+			}
+			rows++
+		}
+	default:
+		r.children(n)
+	}
+}
+
+type textRenderer struct {
+	b strings.Builder
+}
+
+func (r *textRenderer) children(n *node) {
+	for _, c := range n.children {
+		r.render(c)
+	}
+}
+
+func (r *textRenderer) line() {
+	s := r.b.String()
+	if len(s) > 0 && !strings.HasSuffix(s, "\n") {
+		r.b.WriteString("\n")
+	}
+}
+
+func (r *textRenderer) render(n *node) {
+	switch n.name {
+	case "":
+		r.b.WriteString(n.text)
+	case "img", "attach":
+	case "media":
+		if href, ok := mediaUrl(n); ok {
+			r.b.WriteString(href)
+		}
+	case "url", "email":
+		r.children(n)
+		// Keep the address when the link has a label.
+		if href, ok := linkTarget(n); ok && n.arg != "" && n.arg != textContent(n) {
+			r.b.WriteString(" (" + strings.TrimPrefix(href, "mailto:") + ")")
+		}
+	case "code", "php", "html", "quote", "spoiler", "list", "left", "center", "right", "indent", "heading", "table", "tr":
+		r.line()
+		r.children(n)
+		r.line()
+	case "*":
+		r.line()
+		r.b.WriteString("- ")
+		r.children(n)
+	case "th", "td":
+		r.children(n)
+		r.b.WriteString("\t")
+	default:
+		r.children(n)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package collection
+
+import (
+	"carbon/domain"
+	"context"
+
+	"github.com/apex/log"
+	"gorm.io/gorm"
+)
+
+// Manager stores the collections users put together.
+type Manager struct {
+	db *gorm.DB
+}
+
+func NewManager(ctx context.Context, db *gorm.DB) (*Manager, error) {
+	m := &Manager{db: db}
+	err := m.init()
+	return m, err
+}
+
+func (m *Manager) init() error {
+	log.Info("initializing collection schema into the database...")
+
+	if err := m.db.AutoMigrate(&domain.Collection{}, &domain.CollectionItem{}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// withItems preloads the items of the collections in order.
+func withItems(db *gorm.DB) *gorm.DB {
+	return db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC")
+	})
+}
+
+// Find returns the collection with the given ID along with its items.
+func (m *Manager) Find(ctx context.Context, id uint) (domain.Collection, error) {
+	var c domain.Collection
+	if err := withItems(m.db.WithContext(ctx)).First(&c, id).Error; err != nil {
+		return domain.Collection{}, err
+	}
+	return c, nil
+}
+
+// ByUser returns every collection of the user, public or not, most recently
+// updated first.
+func (m *Manager) ByUser(ctx context.Context, uid int) ([]domain.Collection, error) {
+	var c []domain.Collection
+	err := withItems(m.db.WithContext(ctx)).Where("user_id = ?", uid).Order("updated_at DESC").Find(&c).Error
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Public returns a page of the public collections, most recently updated
+// first, along with the total number of public collections.
+func (m *Manager) Public(ctx context.Context, offset int, limit int) ([]domain.Collection, int64, error) {
+	q := m.db.WithContext(ctx).Model(&domain.Collection{}).Where("public = ?", true)
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var c []domain.Collection
+	if err := withItems(q).Order("updated_at DESC").Offset(offset).Limit(limit).Find(&c).Error; err != nil {
+		return nil, 0, err
+	}
+	return c, total, nil
+}
+
+// Create stores a new collection with its items. Items are positioned in the
+// order they are given.
+func (m *Manager) Create(ctx context.Context, c *domain.Collection) error {
+	position(c.Items)
+	return m.db.WithContext(ctx).Create(c).Error
+}
+
+// Update saves the details of the collection and replaces its items.
+func (m *Manager) Update(ctx context.Context, c *domain.Collection) error {
+	position(c.Items)
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", c.ID).Delete(&domain.CollectionItem{}).Error; err != nil {
+			return err
+		}
+		for i := range c.Items {
+			c.Items[i].ID = 0
+			c.Items[i].CollectionId = c.ID
+		}
+		// The items were just recreated, so they are not saved again
+		// along with the collection.
+		if len(c.Items) > 0 {
+			if err := tx.Create(&c.Items).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Omit("Items").Save(c).Error
+	})
+}
+
+// Delete removes the collection and its items.
+func (m *Manager) Delete(ctx context.Context, id uint) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", id).Delete(&domain.CollectionItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&domain.Collection{}, id).Error
+	})
+}
+
+func position(items []domain.CollectionItem) {
+	for i := range items {
+		items[i].Position = i
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package content
+
+import (
+	"archive/zip"
+	"bufio"
+	"carbon/domain"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Limits applied while scanning an archive. We never extract anything to
+// disk, but descriptors are decompressed into memory so a malicious archive
+// must not be able to exhaust it.
+const (
+	maxEntries         = 20000
+	maxDescriptorSize  = 4 * 1024 * 1024
+	maxDescriptorBytes = 64 * 1024 * 1024
+	maxCompression     = 200
+)
+
+var (
+	ErrTooManyEntries = errors.New("content: archive has too many entries")
+	ErrTooMuchData    = errors.New("content: archive descriptors exceed the size limit")
+)
+
+// descriptorTypes maps the extensions of RoR content descriptors to the type
+// of content they describe.
+var descriptorTypes = map[string]string{
+	".truck":    "truck",
+	".car":      "car",
+	".boat":     "boat",
+	".airplane": "airplane",
+	".trailer":  "trailer",
+	".train":    "train",
+	".load":     "load",
+	".fixed":    "fixed",
+	".terrn2":   "terrain",
+	".skin":     "skin",
+}
+
+// scanArchive enumerates the entries of a zip archive and parses every
+// content descriptor it finds.
+func scanArchive(r *zip.Reader) ([]domain.ContentEntry, error) {
+	if len(r.File) > maxEntries {
+		return nil, ErrTooManyEntries
+	}
+
+	var entries []domain.ContentEntry
+	var budget int64 = maxDescriptorBytes
+	for _, f := range r.File {
+		name, ok := safePath(f.Name)
+		if !ok || f.FileInfo().IsDir() {
+			continue
+		}
+
+		t, ok := descriptorTypes[strings.ToLower(path.Ext(name))]
+		if !ok {
+			continue
+		}
+
+		// Skip anything that claims to be absurdly large or compressed
+		// beyond what plain text descriptors ever are.
+		if f.UncompressedSize64 > maxDescriptorSize ||
+			(f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > maxCompression) {
+			continue
+		}
+
+		b, err := readEntry(f, &budget)
+		if err != nil {
+			if errors.Is(err, ErrTooMuchData) {
+				return nil, err
+			}
+			continue
+		}
+
+		var parsed []domain.ContentEntry
+		switch t {
+		case "terrain":
+			parsed = parseTerrn2(b)
+		case "skin":
+			parsed = parseSkin(b)
+		default:
+			parsed = parseTruck(b)
+		}
+
+		for _, e := range parsed {
+			e.Type = t
+			e.Path = name
+			if e.Name == "" {
+				e.Name = strings.TrimSuffix(path.Base(name), path.Ext(name))
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// readEntry decompresses a single entry. The declared sizes in the archive
+// cannot be trusted, so the actual number of decompressed bytes is limited
+// both per entry and for the archive as a whole.
+func readEntry(f *zip.File, budget *int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	limit := int64(maxDescriptorSize)
+	if *budget < limit {
+		limit = *budget
+	}
+
+	b, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	*budget -= int64(len(b))
+	if int64(len(b)) > limit {
+		if *budget <= 0 {
+			return nil, ErrTooMuchData
+		}
+		return nil, errors.New("content: descriptor exceeds the size limit")
+	}
+	return b, nil
+}
+
+// safePath normalizes the name of an archive entry and rejects anything that
+// would escape the archive root, such as absolute paths or ".." segments.
+func safePath(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsRune(name, 0) {
+		return "", false
+	}
+	if len(name) > 1 && name[1] == ':' {
+		return "", false
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	return path.Clean(name), true
+}
+
+// parseTruck reads a truck-like descriptor (.truck, .load, ...). The first
+// meaningful line is the name of the vehicle, the guid section holds its
+// GUID and the fileinfo section its category.
+func parseTruck(b []byte) []domain.ContentEntry {
+	var e domain.ContentEntry
+	s := bufio.NewScanner(strings.NewReader(string(b)))
+	s.Buffer(make([]byte, 0, 64*1024), maxDescriptorSize)
+	first := true
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if first {
+			e.Name = line
+			first = false
+			continue
+		}
+
+		keyword, args := splitKeyword(line)
+		switch keyword {
+		case "guid":
+			e.Guid = normalizeGuid(args)
+		case "fileinfo":
+			// fileinfo <uid>, <category id>, <file version>
+			parts := splitArgs(args)
+			if len(parts) > 1 {
+				e.CategoryId, _ = strconv.Atoi(parts[1])
+			}
+		case "end":
+			return []domain.ContentEntry{e}
+		}
+	}
+	return []domain.ContentEntry{e}
+}
+
+// parseTerrn2 reads a terrain descriptor, which uses an INI-like format with
+// the details we are interested in under the [General] section.
+func parseTerrn2(b []byte) []domain.ContentEntry {
+	var e domain.ContentEntry
+	section := ""
+	s := bufio.NewScanner(strings.NewReader(string(b)))
+	s.Buffer(make([]byte, 0, 64*1024), maxDescriptorSize)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			continue
+		}
+		if section != "general" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "name":
+			e.Name = v
+		case "guid":
+			e.Guid = normalizeGuid(v)
+		case "categoryid":
+			e.CategoryId, _ = strconv.Atoi(v)
+		}
+	}
+	return []domain.ContentEntry{e}
+}
+
+// parseSkin reads a skin descriptor. A single file can define several skins,
+// each one a block opened by its name and enclosed in braces. The GUID of a
+// skin is the GUID of the vehicle it applies to.
+func parseSkin(b []byte) []domain.ContentEntry {
+	var entries []domain.ContentEntry
+	var current *domain.ContentEntry
+	var header string
+	s := bufio.NewScanner(strings.NewReader(string(b)))
+	s.Buffer(make([]byte, 0, 64*1024), maxDescriptorSize)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		switch {
+		case line == "{":
+			current = &domain.ContentEntry{Name: header}
+		case line == "}":
+			if current != nil {
+				entries = append(entries, *current)
+			}
+			current = nil
+		case current == nil:
+			header = line
+		default:
+			keyword, args := splitKeyword(line)
+			switch keyword {
+			case "name":
+				current.Name = args
+			case "guid":
+				current.Guid = normalizeGuid(args)
+			}
+		}
+	}
+	return entries
+}
+
+func splitKeyword(line string) (string, string) {
+	i := strings.IndexAny(line, " \t,:")
+	if i < 0 {
+		return strings.ToLower(line), ""
+	}
+	return strings.ToLower(line[:i]), strings.TrimSpace(strings.TrimLeft(line[i:], " \t,:"))
+}
+
+func splitArgs(args string) []string {
+	parts := strings.FieldsFunc(args, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	return parts
+}
+
+func normalizeGuid(v string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(v), "{}\"'"))
+}
@@ -0,0 +1,297 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package content
+
+import (
+	"archive/zip"
+	"carbon/config"
+	"carbon/domain"
+	"carbon/internal/mirror"
+	"carbon/remote"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"gorm.io/gorm"
+)
+
+var ErrArchiveTooLarge = errors.New("content: archive exceeds the size limit")
+
+// maxAttempts is the number of times a file is tried when it keeps failing
+// with transient errors.
+const maxAttempts = 3
+
+type job struct {
+	resourceId int
+	file       domain.ResourceFile
+}
+
+// Manager indexes the game content found inside resource files. New files
+// are picked up after every resource refresh and handed to a background
+// worker which downloads and scans them one at a time.
+type Manager struct {
+	db      *gorm.DB
+	client  remote.Client
+	mirror  *mirror.Manager
+	enabled bool
+	maxSize int64
+
+	mu       sync.Mutex
+	indexed  map[uint]bool
+	queued   map[uint]bool
+	attempts map[uint]int
+	queue    chan job
+}
+
+func NewManager(ctx context.Context, db *gorm.DB, client remote.Client, mm *mirror.Manager) (*Manager, error) {
+	cfg := config.Get().Content
+	m := &Manager{
+		db:       db,
+		client:   client,
+		mirror:   mm,
+		enabled:  cfg.Enabled,
+		maxSize:  cfg.MaxArchiveSize * 1024 * 1024,
+		indexed:  make(map[uint]bool),
+		queued:   make(map[uint]bool),
+		attempts: make(map[uint]int),
+		queue:    make(chan job, 1024),
+	}
+	if m.maxSize <= 0 {
+		m.maxSize = 512 * 1024 * 1024
+	}
+
+	if err := m.init(); err != nil {
+		return m, err
+	}
+
+	if m.enabled {
+		go m.work(ctx)
+	}
+
+	return m, nil
+}
+
+func (m *Manager) init() error {
+	log.Info("initializing content index schema...")
+
+	if err := m.db.AutoMigrate(&domain.ContentEntry{}, &domain.IndexedFile{}); err != nil {
+		return err
+	}
+
+	var ids []uint
+	if err := m.db.Model(&domain.IndexedFile{}).Pluck("file_id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		m.indexed[id] = true
+	}
+
+	return nil
+}
+
+// Enqueue schedules every current file of the resources that has not been
+// indexed yet. Files that do not fit in the queue are picked up again on the
+// next call.
+func (m *Manager) Enqueue(resources []*domain.Resource) {
+	if !m.enabled {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range resources {
+		for _, f := range r.CurrentFiles {
+			if m.indexed[f.Id] || m.queued[f.Id] || !isArchive(f.FileName) {
+				continue
+			}
+			select {
+			case m.queue <- job{resourceId: r.ResourceId, file: f}:
+				m.queued[f.Id] = true
+			default:
+				return
+			}
+		}
+	}
+}
+
+func (m *Manager) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-m.queue:
+			err := m.safeIndex(ctx, j)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error":       err,
+					"resource_id": j.resourceId,
+					"file_id":     j.file.Id,
+				}).Warn("failed to index resource file")
+			}
+
+			m.mu.Lock()
+			delete(m.queued, j.file.Id)
+			// Network errors are worth another try on the next refresh, a
+			// few times at most. Anything else would most likely fail the
+			// same way again.
+			m.attempts[j.file.Id]++
+			if err == nil || !isTransient(err) || m.attempts[j.file.Id] >= maxAttempts {
+				m.indexed[j.file.Id] = true
+				delete(m.attempts, j.file.Id)
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// safeIndex indexes the file, turning a panic into an error so that a bad
+// archive or upstream response cannot take the whole server down.
+func (m *Manager) safeIndex(ctx context.Context, j job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("content: panic while indexing: %v", r)
+		}
+	}()
+	return m.index(ctx, j)
+}
+
+// index downloads the file, scans it and replaces whatever was previously
+// indexed for it.
+func (m *Manager) index(ctx context.Context, j job) error {
+	f, size, cleanup, err := m.open(ctx, j.file)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var entries []domain.ContentEntry
+	zr, err := zip.NewReader(f, size)
+	if err == nil {
+		entries, err = scanArchive(zr)
+	}
+
+	record := domain.IndexedFile{
+		FileId:     j.file.Id,
+		ResourceId: j.resourceId,
+		IndexedAt:  time.Now(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	for i := range entries {
+		entries[i].ResourceId = j.resourceId
+		entries[i].FileId = j.file.Id
+		entries[i].FileName = j.file.FileName
+	}
+
+	txErr := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("file_id = ?", j.file.Id).Delete(&domain.ContentEntry{}).Error; err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			if err := tx.Create(&entries).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(&record).Error
+	})
+	if txErr != nil {
+		return txErr
+	}
+
+	log.WithFields(log.Fields{
+		"resource_id": j.resourceId,
+		"file_id":     j.file.Id,
+		"entries":     len(entries),
+	}).Debug("indexed resource file")
+
+	return err
+}
+
+// open returns the archive from the mirror if it is there, or downloads it to
+// a temporary file otherwise.
+func (m *Manager) open(ctx context.Context, file domain.ResourceFile) (io.ReaderAt, int64, func(), error) {
+	if f, e, ok := m.mirror.Open(file.Id); ok {
+		return f, e.Size, func() { f.Close() }, nil
+	}
+
+	if int64(file.Size) > m.maxSize {
+		return nil, 0, nil, ErrArchiveTooLarge
+	}
+
+	res, err := m.client.GetResourceFile(ctx, file.Id, http.Header{})
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer res.Body.Close()
+
+	dir := filepath.Join(config.Get().RootDirectory, "tmp")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, 0, nil, err
+	}
+	tmp, err := os.CreateTemp(dir, "index-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	n, err := io.Copy(tmp, io.LimitReader(res.Body, m.maxSize+1))
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	if n > m.maxSize {
+		cleanup()
+		return nil, 0, nil, ErrArchiveTooLarge
+	}
+
+	return tmp, n, cleanup, nil
+}
+
+// Find returns every piece of content with the given GUID.
+func (m *Manager) Find(guid string) ([]domain.ContentEntry, error) {
+	var entries []domain.ContentEntry
+	err := m.db.Where("guid = ?", normalizeGuid(guid)).Order("resource_id, file_id").Find(&entries).Error
+	return entries, err
+}
+
+func isArchive(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".zip")
+}
+
+// isTransient returns true for errors worth another try: network errors and
+// server errors from the remote API.
+func isTransient(err error) bool {
+	if remote.IsRequestError(err) {
+		return remote.AsRequestError(err).StatusCode() >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
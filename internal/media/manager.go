@@ -0,0 +1,350 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package media
+
+import (
+	"bytes"
+	"carbon/config"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // GIFs are decoded so that their first frame can be resized.
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrHostNotAllowed = errors.New("media: host is not allowed")
+	ErrNotImage       = errors.New("media: source is not a supported image")
+	ErrInvalidSize    = errors.New("media: size is not allowed")
+)
+
+// Sizes are the dimensions, in pixels, that images can be resized to. Only a
+// handful are allowed so that the cache cannot be filled with variants.
+var Sizes = []int{32, 48, 64, 96, 128, 256, 512}
+
+const (
+	// maxSourceSize is the largest original image that will be downloaded.
+	maxSourceSize = 8 * 1024 * 1024
+	// maxSourcePixels guards against images that are small on the wire but
+	// huge once decoded.
+	maxSourcePixels = 4096 * 4096
+)
+
+// Image is a cached image variant ready to be served.
+type Image struct {
+	Path        string
+	ContentType string
+	Hash        string
+}
+
+type item struct {
+	size       int64
+	lastAccess time.Time
+}
+
+// Manager fetches images from the forum and keeps resized variants of them in
+// a disk cache. The least recently served variants are evicted once the cache
+// grows past its quota.
+type Manager struct {
+	dir     string
+	maxSize int64
+	hosts   []string
+	client  *http.Client
+	group   singleflight.Group
+
+	mu    sync.Mutex
+	items map[string]*item
+	total int64
+}
+
+func NewManager(ctx context.Context) (*Manager, error) {
+	cfg := config.Get()
+	m := &Manager{
+		dir:     filepath.Join(cfg.RootDirectory, "media"),
+		maxSize: cfg.Media.MaxSize * 1024 * 1024,
+		hosts:   cfg.Media.AllowedHosts,
+		items:   make(map[string]*item),
+	}
+	m.client = &http.Client{
+		Timeout: 15 * time.Second,
+		// Every redirect is checked against the allowed hosts as well,
+		// otherwise an allowed host could send us anywhere.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("media: stopped after 5 redirects")
+			}
+			if !m.allowed(req.URL) {
+				return ErrHostNotAllowed
+			}
+			return nil
+		},
+	}
+	if m.maxSize <= 0 {
+		m.maxSize = 512 * 1024 * 1024
+	}
+	// Images hosted on the forum itself are always allowed.
+	if u, err := url.Parse(cfg.Remote.Location); err == nil && u.Hostname() != "" {
+		m.hosts = append(m.hosts, u.Hostname())
+	}
+
+	err := m.init()
+	return m, err
+}
+
+func (m *Manager) init() error {
+	log.WithField("directory", m.dir).Info("loading media cache...")
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(m.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		// Leftovers of a write that was interrupted.
+		if strings.HasSuffix(p, ".tmp") {
+			return os.Remove(p)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		m.items[filepath.Base(p)] = &item{size: info.Size(), lastAccess: info.ModTime()}
+		m.total += info.Size()
+		return nil
+	})
+}
+
+// Get returns the image at src resized to fit in a square of the given size.
+// A size of zero keeps the original dimensions. The image is only fetched and
+// resized the first time, later calls are served from the cache.
+func (m *Manager) Get(ctx context.Context, src string, size int) (Image, error) {
+	if size != 0 && !slices.Contains(Sizes, size) {
+		return Image{}, ErrInvalidSize
+	}
+	u, err := m.resolve(src)
+	if err != nil {
+		return Image{}, err
+	}
+
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s@%d", u, size)))
+	key := hex.EncodeToString(h[:])
+
+	if img, ok := m.lookup(key); ok {
+		return img, nil
+	}
+
+	// Concurrent requests for the same variant share a single fetch.
+	v, err, _ := m.group.Do(key, func() (interface{}, error) {
+		if img, ok := m.lookup(key); ok {
+			return img, nil
+		}
+		return m.create(ctx, key, u, size)
+	})
+	if err != nil {
+		return Image{}, err
+	}
+	return v.(Image), nil
+}
+
+// resolve makes the source URL absolute and checks it against the allowed
+// hosts, so that the proxy cannot be used to reach arbitrary servers.
+func (m *Manager) resolve(src string) (string, error) {
+	base, err := url.Parse(config.Get().Remote.Location)
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(src)
+	if err != nil {
+		return "", err
+	}
+	if !m.allowed(u) {
+		return "", ErrHostNotAllowed
+	}
+	return u.String(), nil
+}
+
+// allowed returns true if images may be fetched from the URL.
+func (m *Manager) allowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	for _, h := range m.hosts {
+		if strings.EqualFold(u.Hostname(), h) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) lookup(key string) (Image, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ext := range []string{".png", ".jpg"} {
+		it, ok := m.items[key+ext]
+		if !ok {
+			continue
+		}
+		p := m.path(key + ext)
+		now := time.Now()
+		// The modification time doubles as the last access time, so the
+		// eviction order survives restarts.
+		if err := os.Chtimes(p, now, now); err != nil {
+			delete(m.items, key+ext)
+			m.total -= it.size
+			return Image{}, false
+		}
+		it.lastAccess = now
+		return Image{Path: p, ContentType: contentType(ext), Hash: key}, true
+	}
+	return Image{}, false
+}
+
+func (m *Manager) create(ctx context.Context, key string, src string, size int) (Image, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return Image{}, err
+	}
+	res, err := m.client.Do(req)
+	if err != nil {
+		return Image{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Image{}, fmt.Errorf("media: unexpected status %d fetching image", res.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxSourceSize+1))
+	if err != nil {
+		return Image{}, err
+	}
+	if len(b) > maxSourceSize {
+		return Image{}, ErrNotImage
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil || cfg.Width*cfg.Height > maxSourcePixels {
+		return Image{}, ErrNotImage
+	}
+	src0, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return Image{}, ErrNotImage
+	}
+
+	w, h := fit(cfg.Width, cfg.Height, size)
+	dst := resize(src0, w, h)
+
+	// Keep PNG for anything that may be transparent.
+	var buf bytes.Buffer
+	ext := ".png"
+	if format == "jpeg" {
+		ext = ".jpg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	if err := m.store(key+ext, buf.Bytes()); err != nil {
+		return Image{}, err
+	}
+	return Image{Path: m.path(key + ext), ContentType: contentType(ext), Hash: key}, nil
+}
+
+func (m *Manager) store(name string, b []byte) error {
+	p := m.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if it, ok := m.items[name]; ok {
+		m.total -= it.size
+	}
+	m.items[name] = &item{size: int64(len(b)), lastAccess: time.Now()}
+	m.total += int64(len(b))
+	m.evict()
+	return nil
+}
+
+// evict removes the least recently served variants until the cache fits in
+// its quota. The lock must be held.
+func (m *Manager) evict() {
+	if m.total <= m.maxSize {
+		return
+	}
+
+	names := make([]string, 0, len(m.items))
+	for k := range m.items {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return m.items[names[i]].lastAccess.Before(m.items[names[j]].lastAccess)
+	})
+
+	for _, k := range names {
+		if m.total <= m.maxSize {
+			break
+		}
+		if err := os.Remove(m.path(k)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.WithFields(log.Fields{"file": k, "error": err}).Warn("failed to evict media from cache")
+			continue
+		}
+		m.total -= m.items[k].size
+		delete(m.items, k)
+	}
+}
+
+func (m *Manager) path(name string) string {
+	return filepath.Join(m.dir, name[:2], name)
+}
+
+func contentType(ext string) string {
+	if ext == ".jpg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package media
+
+import (
+	"image"
+	"image/draw"
+)
+
+// fit returns the dimensions of an image of w by h pixels scaled down to fit
+// in a square of the given size, preserving the aspect ratio. Images are
+// never scaled up.
+func fit(w, h, size int) (int, int) {
+	if size <= 0 || (w <= size && h <= size) {
+		return w, h
+	}
+	if w >= h {
+		return size, max(1, h*size/w)
+	}
+	return max(1, w*size/h), size
+}
+
+// resize scales the image down to the given dimensions by averaging the
+// source pixels covered by each destination pixel. This is slower than the
+// usual interpolation filters but does not alias when shrinking large
+// images, which is all we ever do here.
+func resize(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	// Work on premultiplied RGBA so that transparent pixels do not bleed
+	// their color into the average.
+	s := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(s, s.Bounds(), src, b.Min, draw.Src)
+	if sw == w && sh == h {
+		return s
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				i := s.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(s.Pix[i])
+					g += uint32(s.Pix[i+1])
+					bl += uint32(s.Pix[i+2])
+					a += uint32(s.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			o := dst.PixOffset(x, y)
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(bl / n)
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
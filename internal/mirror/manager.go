@@ -0,0 +1,333 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mirror
+
+import (
+	"carbon/config"
+	"carbon/remote"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// ErrTooLarge is returned when a file would not fit in the mirror even if
+// everything else was evicted.
+var ErrTooLarge = errors.New("mirror: file exceeds the mirror quota")
+
+const manifestFile = "manifest.json"
+
+// Entry describes a mirrored resource file. Files are stored by the SHA-256
+// of their content, so two entries with the same hash share a single object
+// on disk.
+type Entry struct {
+	FileId     uint      `json:"file_id"`
+	FileName   string    `json:"filename"`
+	Hash       string    `json:"sha256"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// Manager keeps a local, content-addressed copy of resource files so that
+// downloads do not have to go through XenForo every time. Files are mirrored
+// the first time they are downloaded and the least recently used ones are
+// evicted once the mirror grows past its quota.
+type Manager struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	client   remote.Client
+	enabled  bool
+	dir      string
+	maxSize  int64
+	entries  map[uint]*Entry
+	inflight map[uint]bool
+}
+
+func NewManager(ctx context.Context, client remote.Client) (*Manager, error) {
+	cfg := config.Get().Mirror
+	m := &Manager{
+		ctx:      ctx,
+		client:   client,
+		enabled:  cfg.Enabled,
+		dir:      filepath.Join(config.Get().RootDirectory, "mirror"),
+		maxSize:  cfg.MaxSize * 1024 * 1024,
+		entries:  make(map[uint]*Entry),
+		inflight: make(map[uint]bool),
+	}
+	if m.maxSize <= 0 {
+		m.maxSize = 10 * 1024 * 1024 * 1024
+	}
+
+	if !m.enabled {
+		return m, nil
+	}
+
+	err := m.init()
+	return m, err
+}
+
+func (m *Manager) init() error {
+	log.WithField("directory", m.dir).Info("loading resource file mirror...")
+
+	if err := os.MkdirAll(filepath.Join(m.dir, "objects"), 0o755); err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(filepath.Join(m.dir, manifestFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+
+	// Drop anything whose object went missing behind our back. The manifest
+	// is only written when files are mirrored, the modification time of the
+	// objects is what keeps track of the accesses in between.
+	for _, e := range entries {
+		fi, err := os.Stat(m.objectPath(e.Hash))
+		if err != nil {
+			continue
+		}
+		if fi.ModTime().After(e.LastAccess) {
+			e.LastAccess = fi.ModTime()
+		}
+		m.entries[e.FileId] = e
+	}
+
+	return nil
+}
+
+// Enabled returns true if mirroring is turned on in the configuration.
+func (m *Manager) Enabled() bool {
+	return m.enabled
+}
+
+// Open returns the mirrored copy of the file, if there is one. The caller is
+// responsible for closing the returned file.
+func (m *Manager) Open(fid uint) (*os.File, Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[fid]
+	if !ok {
+		return nil, Entry{}, false
+	}
+
+	f, err := os.Open(m.objectPath(e.Hash))
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "file_id": fid}).Warn("mirrored file could not be opened")
+		delete(m.entries, fid)
+		return nil, Entry{}, false
+	}
+
+	// The modification time doubles as the last access time, so the
+	// eviction order survives restarts.
+	now := time.Now()
+	if err := os.Chtimes(m.objectPath(e.Hash), now, now); err != nil {
+		log.WithFields(log.Fields{"error": err, "file_id": fid}).Warn("failed to touch mirrored file")
+	}
+	e.LastAccess = now
+	return f, *e, true
+}
+
+// Lookup returns the manifest entry for the file without touching it.
+func (m *Manager) Lookup(fid uint) (Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[fid]; ok {
+		return *e, true
+	}
+	return Entry{}, false
+}
+
+// Schedule mirrors the file in the background unless it is already mirrored
+// or being mirrored.
+func (m *Manager) Schedule(fid uint, name string) {
+	if !m.enabled {
+		return
+	}
+
+	m.mu.Lock()
+	if _, ok := m.entries[fid]; ok || m.inflight[fid] {
+		m.mu.Unlock()
+		return
+	}
+	m.inflight[fid] = true
+	m.mu.Unlock()
+
+	go func() {
+		defer func() {
+			m.mu.Lock()
+			delete(m.inflight, fid)
+			m.mu.Unlock()
+		}()
+		// A bad download must never take the whole server down with it.
+		defer func() {
+			if r := recover(); r != nil {
+				log.WithFields(log.Fields{"panic": r, "file_id": fid}).Error("recovered from panic while mirroring resource file")
+			}
+		}()
+
+		if err := m.Fetch(m.ctx, fid, name); err != nil {
+			log.WithFields(log.Fields{"error": err, "file_id": fid}).Warn("failed to mirror resource file")
+		}
+	}()
+}
+
+// Fetch downloads the file from the remote API into the mirror.
+func (m *Manager) Fetch(ctx context.Context, fid uint, name string) error {
+	res, err := m.client.GetResourceFile(ctx, fid, http.Header{})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	tmp, err := os.CreateTemp(m.dir, "fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// Never write more than the quota allows, whatever the remote claims.
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(res.Body, m.maxSize+1))
+	if err != nil {
+		return err
+	}
+	if n > m.maxSize {
+		return ErrTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+	p := m.objectPath(hash)
+
+	// The object is moved into place under the lock, otherwise an eviction
+	// running in between could delete it before its entry is added.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	m.entries[fid] = &Entry{
+		FileId:     fid,
+		FileName:   name,
+		Hash:       hash,
+		Size:       n,
+		CreatedAt:  now,
+		LastAccess: now,
+	}
+	m.evict()
+
+	log.WithFields(log.Fields{"file_id": fid, "sha256": hash, "size": n}).Debug("mirrored resource file")
+
+	return m.save()
+}
+
+// evict removes the least recently used files until the mirror fits in its
+// quota. Objects are only deleted once no entry references them anymore.
+func (m *Manager) evict() {
+	sizes := make(map[string]int64)
+	for _, e := range m.entries {
+		sizes[e.Hash] = e.Size
+	}
+	var total int64
+	for _, s := range sizes {
+		total += s
+	}
+	if total <= m.maxSize {
+		return
+	}
+
+	entries := make([]*Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastAccess.Before(entries[j].LastAccess)
+	})
+
+	refs := make(map[string]int)
+	for _, e := range entries {
+		refs[e.Hash]++
+	}
+
+	for _, e := range entries {
+		if total <= m.maxSize {
+			break
+		}
+		delete(m.entries, e.FileId)
+		refs[e.Hash]--
+		if refs[e.Hash] > 0 {
+			continue
+		}
+		if err := os.Remove(m.objectPath(e.Hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.WithFields(log.Fields{"error": err, "sha256": e.Hash}).Warn("failed to evict mirrored file")
+		}
+		total -= e.Size
+	}
+}
+
+// save writes the manifest to disk. It must be called with the lock held.
+func (m *Manager) save() error {
+	entries := make([]*Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FileId < entries[j].FileId
+	})
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	p := filepath.Join(m.dir, manifestFile)
+	if err := os.WriteFile(p+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+func (m *Manager) objectPath(hash string) string {
+	return filepath.Join(m.dir, "objects", hash[:2], hash)
+}
@@ -0,0 +1,171 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package notification
+
+import (
+	"carbon/domain"
+	"context"
+	"time"
+
+	"github.com/apex/log"
+	"gorm.io/gorm"
+)
+
+// Manager stores the resources users follow and the notifications they
+// receive when one of those resources is updated.
+type Manager struct {
+	db *gorm.DB
+}
+
+func NewManager(ctx context.Context, db *gorm.DB) (*Manager, error) {
+	m := &Manager{db: db}
+	err := m.init()
+	return m, err
+}
+
+func (m *Manager) init() error {
+	log.Info("initializing notification schema into the database...")
+
+	if err := m.db.AutoMigrate(&domain.Follow{}, &domain.Notification{}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Follow subscribes the user to the resource. Following a resource twice is
+// not an error.
+func (m *Manager) Follow(uid int, rid int) (domain.Follow, error) {
+	f := domain.Follow{UserId: uid, ResourceId: rid}
+	if err := m.db.Where(&f).FirstOrCreate(&f).Error; err != nil {
+		return domain.Follow{}, err
+	}
+	return f, nil
+}
+
+func (m *Manager) Unfollow(uid int, rid int) error {
+	return m.db.Where("user_id = ? AND resource_id = ?", uid, rid).Delete(&domain.Follow{}).Error
+}
+
+func (m *Manager) Follows(uid int) ([]domain.Follow, error) {
+	var follows []domain.Follow
+	if err := m.db.Where("user_id = ?", uid).Order("created_at DESC").Find(&follows).Error; err != nil {
+		return nil, err
+	}
+	return follows, nil
+}
+
+// Notifications returns a page of the user's notifications, newest first,
+// along with the total number of matching notifications.
+func (m *Manager) Notifications(uid int, unreadOnly bool, offset int, limit int) ([]domain.Notification, int64, error) {
+	q := m.db.Model(&domain.Notification{}).Where("user_id = ?", uid)
+	if unreadOnly {
+		q = q.Where("`read` = ?", false)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var n []domain.Notification
+	if err := q.Order("updated_at DESC").Offset(offset).Limit(limit).Find(&n).Error; err != nil {
+		return nil, 0, err
+	}
+	return n, total, nil
+}
+
+// UnreadCount returns the number of unread notifications of the user. Since
+// unread notifications are folded per resource, this is also the number of
+// followed resources that were updated since the user last looked.
+func (m *Manager) UnreadCount(uid int) (int64, error) {
+	var n int64
+	err := m.db.Model(&domain.Notification{}).Where("user_id = ? AND `read` = ?", uid, false).Count(&n).Error
+	return n, err
+}
+
+// MarkRead marks the given notifications of the user as read. Every unread
+// notification is marked when no IDs are given.
+func (m *Manager) MarkRead(uid int, ids []uint) error {
+	q := m.db.Model(&domain.Notification{}).Where("user_id = ? AND `read` = ?", uid, false)
+	if len(ids) > 0 {
+		q = q.Where("id IN ?", ids)
+	}
+	now := time.Now()
+	return q.Updates(map[string]interface{}{"read": true, "read_at": &now}).Error
+}
+
+// ResourcesUpdated is meant to be registered as a resource refresh hook. It
+// notifies the followers of every resource whose version changed between the
+// two collections. Resources that were not known before are skipped, as are
+// those of the very first refresh when there is nothing to compare with.
+func (m *Manager) ResourcesUpdated(ctx context.Context, prev, next []*domain.Resource) {
+	versions := make(map[int]string, len(prev))
+	for _, r := range prev {
+		versions[r.ResourceId] = r.Version
+	}
+
+	for _, r := range next {
+		old, ok := versions[r.ResourceId]
+		if !ok || old == "" || old == r.Version {
+			continue
+		}
+		if err := m.notify(ctx, r, old); err != nil {
+			log.WithFields(log.Fields{"resource": r.ResourceId, "error": err}).Warn("failed to notify resource followers")
+		}
+	}
+}
+
+func (m *Manager) notify(ctx context.Context, r *domain.Resource, previous string) error {
+	db := m.db.WithContext(ctx)
+
+	var followers []int
+	if err := db.Model(&domain.Follow{}).Where("resource_id = ?", r.ResourceId).Pluck("user_id", &followers).Error; err != nil {
+		return err
+	}
+	if len(followers) == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, uid := range followers {
+			// Fold into the unread notification if there is one, keeping
+			// the version the user was last told about.
+			res := tx.Model(&domain.Notification{}).
+				Where("user_id = ? AND resource_id = ? AND type = ? AND `read` = ?", uid, r.ResourceId, domain.NotificationResourceUpdate, false).
+				Updates(map[string]interface{}{"title": r.Title, "version": r.Version})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				continue
+			}
+
+			n := domain.Notification{
+				UserId:          uid,
+				ResourceId:      r.ResourceId,
+				Type:            domain.NotificationResourceUpdate,
+				Title:           r.Title,
+				Version:         r.Version,
+				PreviousVersion: previous,
+			}
+			if err := tx.Create(&n).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,245 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package related
+
+import (
+	"carbon/config"
+	"carbon/domain"
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// minSupport is the number of users that must have fetched two
+	// resources before they are considered related.
+	minSupport = 2
+
+	// maxBasket is the number of resources a user can fetch before they are
+	// left out of the model. Such users are most likely mirroring everything
+	// and tell nothing about which resources go together.
+	maxBasket = 200
+
+	// maxRelated is the number of related resources kept per resource.
+	maxRelated = 50
+)
+
+type scored struct {
+	id    int
+	score float64
+}
+
+// Manager records which resources users fetch and recommends resources that
+// were fetched by the same users. The co-occurrence model is computed
+// periodically in the background and kept in memory.
+type Manager struct {
+	db *gorm.DB
+
+	mu       sync.RWMutex
+	related  map[int][]scored
+	computed time.Time
+}
+
+func NewManager(ctx context.Context, db *gorm.DB) (*Manager, error) {
+	m := &Manager{db: db, related: make(map[int][]scored)}
+	if err := m.init(); err != nil {
+		return m, err
+	}
+
+	go m.work(ctx)
+
+	return m, nil
+}
+
+func (m *Manager) init() error {
+	log.Info("initializing related resources schema into the database...")
+
+	if err := m.db.AutoMigrate(&domain.ResourceFetch{}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func interval() time.Duration {
+	if v := config.Get().Related.Interval; v > 0 {
+		return v * time.Minute
+	}
+	return 6 * time.Hour
+}
+
+func retention() time.Duration {
+	if v := config.Get().Related.Retention; v > 0 {
+		return time.Duration(v) * 24 * time.Hour
+	}
+	return 90 * 24 * time.Hour
+}
+
+// work computes the model right away and then again every interval.
+func (m *Manager) work(ctx context.Context) {
+	t := time.NewTicker(interval())
+	defer t.Stop()
+
+	for {
+		if err := m.Compute(ctx); err != nil {
+			log.WithField("error", err).Warn("failed to compute related resources")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Record remembers that the user fetched the resource.
+func (m *Manager) Record(ctx context.Context, uid int, rid int) error {
+	f := domain.ResourceFetch{UserId: uid, ResourceId: rid, FetchedAt: time.Now()}
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "resource_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"fetched_at"}),
+	}).Create(&f).Error
+}
+
+// Compute builds the co-occurrence model from the fetches within the
+// retention period, and purges the older ones. Two resources are scored by
+// the cosine similarity of the sets of users who fetched them, so that
+// resources everyone fetches do not end up related to everything.
+func (m *Manager) Compute(ctx context.Context) error {
+	db := m.db.WithContext(ctx)
+	cutoff := time.Now().Add(-retention())
+
+	if err := db.Where("fetched_at < ?", cutoff).Delete(&domain.ResourceFetch{}).Error; err != nil {
+		return err
+	}
+
+	var fetches []domain.ResourceFetch
+	if err := db.Select("user_id", "resource_id").Find(&fetches).Error; err != nil {
+		return err
+	}
+
+	baskets := make(map[int][]int)
+	for _, f := range fetches {
+		baskets[f.UserId] = append(baskets[f.UserId], f.ResourceId)
+	}
+
+	users := make(map[int]int)
+	pairs := make(map[[2]int]int)
+	for _, b := range baskets {
+		if len(b) > maxBasket {
+			continue
+		}
+		for i, a := range b {
+			users[a]++
+			for _, c := range b[i+1:] {
+				pairs[pair(a, c)]++
+			}
+		}
+	}
+
+	related := make(map[int][]scored)
+	for p, n := range pairs {
+		if n < minSupport {
+			continue
+		}
+		score := float64(n) / math.Sqrt(float64(users[p[0]])*float64(users[p[1]]))
+		related[p[0]] = append(related[p[0]], scored{id: p[1], score: score})
+		related[p[1]] = append(related[p[1]], scored{id: p[0], score: score})
+	}
+	for id, s := range related {
+		sort.Slice(s, func(i, j int) bool {
+			if s[i].score != s[j].score {
+				return s[i].score > s[j].score
+			}
+			return s[i].id < s[j].id
+		})
+		if len(s) > maxRelated {
+			related[id] = s[:maxRelated]
+		}
+	}
+
+	m.mu.Lock()
+	m.related = related
+	m.computed = time.Now()
+	m.mu.Unlock()
+
+	log.WithFields(log.Fields{"fetches": len(fetches), "resources": len(related)}).Debug("computed related resources")
+	return nil
+}
+
+// pair orders the resources so that a pair is counted once either way.
+func pair(a, b int) [2]int {
+	if a > b {
+		return [2]int{b, a}
+	}
+	return [2]int{a, b}
+}
+
+// Related returns up to limit resources related to r, picked from resources.
+// Resources also downloaded by the same users come first, the rest is filled
+// with the most downloaded resources of the same author and then of the same
+// category. Resources rejected by the filter are never returned. The time the
+// model was last computed is returned along with them.
+func (m *Manager) Related(r *domain.Resource, resources []*domain.Resource, filter func(*domain.Resource) bool, limit int) ([]domain.RelatedResource, time.Time) {
+	byId := make(map[int]*domain.Resource, len(resources))
+	for _, v := range resources {
+		byId[v.ResourceId] = v
+	}
+
+	res := make([]domain.RelatedResource, 0, limit)
+	seen := map[int]bool{r.ResourceId: true}
+	add := func(v *domain.Resource, reason string, score float64) {
+		if len(res) == limit || v == nil || seen[v.ResourceId] || (filter != nil && !filter(v)) {
+			return
+		}
+		seen[v.ResourceId] = true
+		res = append(res, domain.RelatedResource{ResourceId: v.ResourceId, Reason: reason, Score: score, Resource: v})
+	}
+
+	m.mu.RLock()
+	for _, s := range m.related[r.ResourceId] {
+		add(byId[s.id], domain.RelatedAlsoDownloaded, s.score)
+	}
+	computed := m.computed
+	m.mu.RUnlock()
+
+	if len(res) < limit {
+		popular := make([]*domain.Resource, len(resources))
+		copy(popular, resources)
+		sort.SliceStable(popular, func(i, j int) bool {
+			return popular[i].DownloadCount > popular[j].DownloadCount
+		})
+		for _, v := range popular {
+			if r.UserId != 0 && v.UserId == r.UserId {
+				add(v, domain.RelatedSameAuthor, 0)
+			}
+		}
+		for _, v := range popular {
+			if v.ResourceCategoryId == r.ResourceCategoryId {
+				add(v, domain.RelatedSameCategory, 0)
+			}
+		}
+	}
+
+	return res, computed
+}
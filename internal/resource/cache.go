@@ -0,0 +1,59 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import "sync"
+
+// stampedCache caches a value per resource ID alongside a stamp, such as the
+// resource's LastUpdate. A cached value is only returned while the caller
+// presents the same stamp, so entries go stale as soon as the resource
+// changes on the remote.
+type stampedCache[T any] struct {
+	mu      sync.Mutex
+	entries map[int]stampedEntry[T]
+}
+
+type stampedEntry[T any] struct {
+	stamp uint
+	value T
+}
+
+func newStampedCache[T any]() *stampedCache[T] {
+	return &stampedCache[T]{entries: make(map[int]stampedEntry[T])}
+}
+
+func (c *stampedCache[T]) get(id int, stamp uint) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok || e.stamp != stamp {
+		var zero T
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *stampedCache[T]) put(id int, stamp uint, v T) {
+	c.mu.Lock()
+	c.entries[id] = stampedEntry[T]{stamp: stamp, value: v}
+	c.mu.Unlock()
+}
+
+func (c *stampedCache[T]) invalidate(id int) {
+	c.mu.Lock()
+	delete(c.entries, id)
+	c.mu.Unlock()
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"carbon/domain"
+	"slices"
+	"sort"
+	"time"
+)
+
+// PutCategories replaces every category in the collection.
+func (m *Manager) PutCategories(c []*domain.ResourceCategory) {
+	m.mu.Lock()
+	m.categories = c
+	m.categoryGeneration.update(c, time.Now())
+	m.mu.Unlock()
+}
+
+func (m *Manager) FindCategory(filter func(match *domain.ResourceCategory) bool) *domain.ResourceCategory {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, v := range m.categories {
+		if filter(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+func (m *Manager) Categories() []*domain.ResourceCategory {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.categories
+}
+
+// CategoryTree returns the category hierarchy built from each category's
+// parent. Siblings are sorted by their display order and every node carries
+// the number of resources in its whole subtree. A category whose parent is
+// unknown is treated as a root so that it is never silently dropped, and so
+// is the category with the lowest id of every cycle of parents.
+func (m *Manager) CategoryTree() []*domain.ResourceCategoryNode {
+	categories := m.Categories()
+
+	nodes := make(map[int]*domain.ResourceCategoryNode, len(categories))
+	for _, c := range categories {
+		nodes[c.ResourceCategoryId] = &domain.ResourceCategoryNode{
+			ResourceCategory: *c,
+			Children:         []*domain.ResourceCategoryNode{},
+		}
+	}
+
+	detached := make(map[int]bool)
+	isRoot := func(n *domain.ResourceCategoryNode) bool {
+		parent, ok := nodes[int(n.ParentCategoryId)]
+		return n.ParentCategoryId == 0 || !ok || parent == n || detached[n.ResourceCategoryId]
+	}
+
+	// Walk up from every category, a category seen twice on the way means
+	// the parents loop back and none of them would ever reach a root.
+	for _, c := range categories {
+		n := nodes[c.ResourceCategoryId]
+		var path []*domain.ResourceCategoryNode
+		for !isRoot(n) {
+			if i := slices.Index(path, n); i >= 0 {
+				lowest := path[i]
+				for _, v := range path[i:] {
+					if v.ResourceCategoryId < lowest.ResourceCategoryId {
+						lowest = v
+					}
+				}
+				detached[lowest.ResourceCategoryId] = true
+				break
+			}
+			path = append(path, n)
+			n = nodes[int(n.ParentCategoryId)]
+		}
+	}
+
+	var roots []*domain.ResourceCategoryNode
+	for _, c := range categories {
+		n := nodes[c.ResourceCategoryId]
+		if isRoot(n) {
+			roots = append(roots, n)
+			continue
+		}
+		parent := nodes[int(c.ParentCategoryId)]
+		parent.Children = append(parent.Children, n)
+	}
+
+	for _, n := range roots {
+		aggregate(n, make(map[*domain.ResourceCategoryNode]bool))
+	}
+	sortNodes(roots)
+
+	return roots
+}
+
+// aggregate sums the resource counts of the subtree into each node. The seen
+// map guards against a malformed hierarchy that loops back on itself.
+func aggregate(n *domain.ResourceCategoryNode, seen map[*domain.ResourceCategoryNode]bool) uint {
+	if seen[n] {
+		return 0
+	}
+	seen[n] = true
+
+	n.TotalResourceCount = n.ResourceCount
+	for _, child := range n.Children {
+		n.TotalResourceCount += aggregate(child, seen)
+	}
+	return n.TotalResourceCount
+}
+
+func sortNodes(nodes []*domain.ResourceCategoryNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].DisplayOrder != nodes[j].DisplayOrder {
+			return nodes[i].DisplayOrder < nodes[j].DisplayOrder
+		}
+		return nodes[i].ResourceCategoryId < nodes[j].ResourceCategoryId
+	})
+	for _, n := range nodes {
+		sortNodes(n.Children)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"carbon/domain"
+	"context"
+	"sort"
+)
+
+// releaseWindow is how far apart, in seconds, a version and an update post
+// can be published and still be considered the same release. XenForo creates
+// both when an author releases a version with release notes.
+const releaseWindow = 15 * 60
+
+// Changelog merges the versions and the update posts of the resource into a
+// single timeline, newest first. Messages are left as BBCode.
+func (m *Manager) Changelog(ctx context.Context, r *domain.Resource) ([]domain.ChangelogEntry, error) {
+	versions, err := m.Versions(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	updates, err := m.Updates(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]domain.ChangelogEntry, 0, len(versions)+len(updates))
+	matched := make(map[int]bool)
+	for i, v := range versions {
+		e := domain.ChangelogEntry{
+			Type:              domain.ChangelogVersion,
+			Date:              v.ReleaseDate,
+			ResourceVersionId: v.ResourceVersionId,
+			VersionString:     v.VersionString,
+			Files:             v.Files,
+		}
+		// Versions are sorted newest first, so the previous one is next.
+		if i+1 < len(versions) {
+			e.Diff = diffFiles(versions[i+1].Files, v.Files)
+		}
+
+		if u, ok := closestUpdate(updates, v.ReleaseDate, matched); ok {
+			matched[u.ResourceUpdateId] = true
+			e.Type = domain.ChangelogRelease
+			e.ResourceUpdateId = u.ResourceUpdateId
+			e.Title = u.Title
+			e.Message = u.Message
+		}
+		entries = append(entries, e)
+	}
+
+	for _, u := range updates {
+		if matched[u.ResourceUpdateId] {
+			continue
+		}
+		entries = append(entries, domain.ChangelogEntry{
+			Type:             domain.ChangelogUpdate,
+			Date:             u.PostDate,
+			ResourceUpdateId: u.ResourceUpdateId,
+			Title:            u.Title,
+			Message:          u.Message,
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Date > entries[j].Date
+	})
+	return entries, nil
+}
+
+// closestUpdate returns the update posted closest to the release date of a
+// version, if any was posted within the release window.
+func closestUpdate(updates []domain.ResourceUpdate, date uint, matched map[int]bool) (domain.ResourceUpdate, bool) {
+	var best domain.ResourceUpdate
+	bestDelta := uint(releaseWindow + 1)
+	for _, u := range updates {
+		if matched[u.ResourceUpdateId] {
+			continue
+		}
+		delta := max(u.PostDate, date) - min(u.PostDate, date)
+		if delta < bestDelta {
+			best, bestDelta = u, delta
+		}
+	}
+	return best, bestDelta <= releaseWindow
+}
+
+func diffFiles(prev, next []domain.ResourceFile) *domain.FileDiff {
+	d := &domain.FileDiff{
+		Added:   []domain.ResourceFile{},
+		Removed: []domain.ResourceFile{},
+		Changed: []domain.FileChange{},
+	}
+
+	old := make(map[string]domain.ResourceFile, len(prev))
+	for _, f := range prev {
+		old[f.FileName] = f
+	}
+	seen := make(map[string]bool, len(next))
+	for _, f := range next {
+		seen[f.FileName] = true
+		p, ok := old[f.FileName]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, f)
+		case p.Size != f.Size:
+			d.Changed = append(d.Changed, domain.FileChange{ResourceFile: f, PreviousSize: p.Size})
+		}
+	}
+	for _, f := range prev {
+		if !seen[f.FileName] {
+			d.Removed = append(d.Removed, f)
+		}
+	}
+	return d
+}
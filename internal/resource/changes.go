@@ -0,0 +1,234 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"carbon/domain"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"sort"
+)
+
+// ErrCursorExpired is returned when a change feed cursor is older than the
+// oldest change we still remember, or was not issued by this instance. The
+// client has to reload every resource and start over with a fresh cursor.
+var ErrCursorExpired = errors.New("resource: change feed cursor has expired")
+
+// ErrInvalidCursor is returned when a cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("resource: invalid change feed cursor")
+
+// maxChanges bounds the number of changes kept in the log.
+const maxChanges = 50000
+
+type change struct {
+	Sequence   uint64 `json:"seq"`
+	Type       string `json:"type"`
+	ResourceId int    `json:"resource_id"`
+}
+
+// changeLog records which resources were added, updated or deleted by each
+// refresh. Every change gets the next sequence number, and a cursor is simply
+// the last sequence number a client has seen. The log is persisted with the
+// snapshot so that cursors remain valid across restarts.
+type changeLog struct {
+	Sequence uint64   `json:"sequence"`
+	Floor    uint64   `json:"floor"`
+	Entries  []change `json:"entries"`
+
+	// hashes holds a content hash of every known resource and is rebuilt
+	// from the cache on startup rather than persisted.
+	hashes  map[int]uint64
+	indexed bool
+}
+
+func newChangeLog(seq uint64) *changeLog {
+	return &changeLog{Sequence: seq, Floor: seq, hashes: make(map[int]uint64)}
+}
+
+// index remembers the current state of the resources without recording any
+// change.
+func (l *changeLog) index(resources []*domain.Resource) {
+	l.hashes = make(map[int]uint64, len(resources))
+	for _, r := range resources {
+		l.hashes[r.ResourceId] = hashResource(r)
+	}
+	l.indexed = true
+}
+
+// record diffs the resources against the previous state and appends a change
+// for everything that differs. The very first population of an empty log is
+// not considered a change.
+func (l *changeLog) record(resources []*domain.Resource) {
+	if !l.indexed {
+		l.index(resources)
+		return
+	}
+
+	next := make(map[int]uint64, len(resources))
+	for _, r := range resources {
+		h := hashResource(r)
+		next[r.ResourceId] = h
+
+		old, ok := l.hashes[r.ResourceId]
+		switch {
+		case !ok:
+			l.append(domain.ResourceAdded, r.ResourceId)
+		case old != h:
+			l.append(domain.ResourceUpdated, r.ResourceId)
+		}
+	}
+
+	var deleted []int
+	for id := range l.hashes {
+		if _, ok := next[id]; !ok {
+			deleted = append(deleted, id)
+		}
+	}
+	sort.Ints(deleted)
+	for _, id := range deleted {
+		l.append(domain.ResourceDeleted, id)
+	}
+
+	l.hashes = next
+
+	if n := len(l.Entries) - maxChanges; n > 0 {
+		l.Floor = l.Entries[n-1].Sequence
+		l.Entries = append([]change(nil), l.Entries[n:]...)
+	}
+}
+
+func (l *changeLog) append(t string, id int) {
+	l.Sequence++
+	l.Entries = append(l.Entries, change{Sequence: l.Sequence, Type: t, ResourceId: id})
+}
+
+// since returns the changes after the cursor.
+func (l *changeLog) since(cursor uint64) ([]change, error) {
+	if cursor < l.Floor || cursor > l.Sequence {
+		return nil, ErrCursorExpired
+	}
+	i := sort.Search(len(l.Entries), func(i int) bool {
+		return l.Entries[i].Sequence > cursor
+	})
+	return l.Entries[i:], nil
+}
+
+func (l *changeLog) clone() *changeLog {
+	return &changeLog{
+		Sequence: l.Sequence,
+		Floor:    l.Floor,
+		Entries:  append([]change(nil), l.Entries...),
+	}
+}
+
+// hashResource hashes everything clients sync on. The view, download, review
+// and rating counters change all the time and are left out, otherwise popular
+// resources would be reported as updated on every refresh.
+func hashResource(r *domain.Resource) uint64 {
+	v := *r
+	v.ViewCount, v.DownloadCount, v.ReviewCount = 0, 0, 0
+	v.RatingCount, v.RatingAvg, v.RatingWeighted = 0, 0, 0
+	b, _ := json.Marshal(&v)
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// EncodeCursor turns a sequence number into the opaque cursor handed out to
+// clients.
+func EncodeCursor(seq uint64) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor is the inverse of EncodeCursor.
+func DecodeCursor(cursor string) (uint64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(b) != 8 {
+		return 0, ErrInvalidCursor
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// AllChanges returns every resource in the cache as an addition, along with
+// the cursor to continue from. It is used by clients without a cursor.
+func (m *Manager) AllChanges() ([]domain.ResourceChange, uint64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]domain.ResourceChange, 0, len(m.resources))
+	for _, r := range m.resources {
+		res = append(res, domain.ResourceChange{
+			Type:       domain.ResourceAdded,
+			ResourceId: r.ResourceId,
+			Resource:   r,
+		})
+	}
+	return res, m.changes.Sequence
+}
+
+// ChangesSince returns the changes after the cursor, collapsed to a single
+// entry per resource that reflects its current state, along with the cursor
+// to continue from.
+func (m *Manager) ChangesSince(cursor uint64) ([]domain.ResourceChange, uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	changes, err := m.changes.since(cursor)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	current := make(map[int]*domain.Resource, len(m.resources))
+	for _, r := range m.resources {
+		current[r.ResourceId] = r
+	}
+
+	first := make(map[int]string)
+	last := make(map[int]uint64)
+	for _, c := range changes {
+		if _, ok := first[c.ResourceId]; !ok {
+			first[c.ResourceId] = c.Type
+		}
+		last[c.ResourceId] = c.Sequence
+	}
+
+	ids := make([]int, 0, len(last))
+	for id := range last {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return last[ids[i]] < last[ids[j]]
+	})
+
+	res := make([]domain.ResourceChange, 0, len(ids))
+	for _, id := range ids {
+		r, ok := current[id]
+		switch {
+		case !ok || r.ResourceState == "deleted":
+			res = append(res, domain.ResourceChange{Type: domain.ResourceDeleted, ResourceId: id})
+		case first[id] == domain.ResourceAdded:
+			res = append(res, domain.ResourceChange{Type: domain.ResourceAdded, ResourceId: id, Resource: r})
+		default:
+			res = append(res, domain.ResourceChange{Type: domain.ResourceUpdated, ResourceId: id, Resource: r})
+		}
+	}
+	return res, m.changes.Sequence, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"carbon/domain"
+	"carbon/internal/version"
+	"regexp"
+	"strings"
+)
+
+var (
+	rangePattern = regexp.MustCompile(`^(.+?)\s*(?:-|–|\.\.|\sto\s)\s*(\d.*)$`)
+	minPattern   = regexp.MustCompile(`^(?:>=\s*(.+)|(.+?)\s*(?:\+|and newer|or newer|or later|and later))$`)
+	maxPattern   = regexp.MustCompile(`^(?:<=\s*(.+)|up to\s+(.+)|(.+?)\s*(?:and older|or older|or earlier))$`)
+)
+
+// parseGameVersions extracts the game versions listed in the game version
+// field. Entries are single versions, ranges such as "2022.04 - 2022.12" or
+// "2022.04-2022.12" and open ranges such as "2022.04+" or "<= 2022.12".
+// Entries without a version number are ignored.
+func parseGameVersions(r *domain.Resource, field string) []domain.GameVersionRange {
+	var ranges []domain.GameVersionRange
+	for _, v := range customField(r, field) {
+		v = strings.ToLower(v)
+		if v == "*" || v == "any" || v == "all" {
+			ranges = append(ranges, domain.GameVersionRange{})
+			continue
+		}
+
+		var rng domain.GameVersionRange
+		if m := rangePattern.FindStringSubmatch(v); m != nil {
+			rng = domain.GameVersionRange{Min: m[1], Max: m[2]}
+		} else if m := minPattern.FindStringSubmatch(v); m != nil {
+			rng = domain.GameVersionRange{Min: m[1] + m[2]}
+		} else if m := maxPattern.FindStringSubmatch(v); m != nil {
+			rng = domain.GameVersionRange{Max: m[1] + m[2] + m[3]}
+		} else {
+			rng = domain.GameVersionRange{Min: v, Max: v}
+		}
+
+		rng.Min, rng.Max = strings.TrimSpace(rng.Min), strings.TrimSpace(rng.Max)
+		if (rng.Min != "" && !version.Parse(rng.Min).Valid()) || (rng.Max != "" && !version.Parse(rng.Max).Valid()) {
+			continue
+		}
+		ranges = append(ranges, rng)
+	}
+	return ranges
+}
+
+// Compatible returns true if the resource works with the game version. A
+// version within a listed version, such as "2022.12.1" for "2022.12", is
+// compatible. Resources that do not list any game version are assumed to
+// work with every version.
+func Compatible(r *domain.Resource, game version.Version) bool {
+	if len(r.GameVersions) == 0 {
+		return true
+	}
+	for _, rng := range r.GameVersions {
+		if rng.Min != "" {
+			lo := version.Parse(rng.Min)
+			if game.Compare(lo) < 0 && !game.HasPrefix(lo) {
+				continue
+			}
+		}
+		if rng.Max != "" {
+			hi := version.Parse(rng.Max)
+			if game.Compare(hi) > 0 && !game.HasPrefix(hi) {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
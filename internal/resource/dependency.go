@@ -0,0 +1,102 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import "carbon/domain"
+
+// ResolveDependencies walks the dependencies of the resource and returns its
+// transitive closure. Resources are listed in install order, so every
+// resource comes after the resources it depends on. Dependencies that are
+// not in the cache, have been deleted, or form a cycle are reported instead
+// of failing the whole resolution.
+func (m *Manager) ResolveDependencies(r *domain.Resource) domain.ResourceDependencies {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	byId := make(map[int]*domain.Resource, len(m.resources))
+	for _, v := range m.resources {
+		byId[v.ResourceId] = v
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	res := domain.ResourceDependencies{
+		Resources: []*domain.Resource{},
+		Missing:   []int{},
+		Deleted:   []int{},
+		Cycles:    [][]int{},
+	}
+	state := make(map[int]int)
+	reported := make(map[int]bool)
+	var stack []int
+
+	var visit func(id int)
+	visit = func(id int) {
+		switch state[id] {
+		case visited:
+			return
+		case visiting:
+			// Everything on the stack from the first occurrence of the ID
+			// onwards forms the cycle.
+			for i := range stack {
+				if stack[i] == id {
+					cycle := append([]int(nil), stack[i:]...)
+					res.Cycles = append(res.Cycles, append(cycle, id))
+					break
+				}
+			}
+			return
+		}
+
+		dep, ok := byId[id]
+		if !ok || dep.ResourceState == "deleted" {
+			if !reported[id] {
+				reported[id] = true
+				if ok {
+					res.Deleted = append(res.Deleted, id)
+				} else {
+					res.Missing = append(res.Missing, id)
+				}
+			}
+			state[id] = visited
+			return
+		}
+
+		state[id] = visiting
+		stack = append(stack, id)
+		for _, next := range dep.Dependencies {
+			visit(next)
+		}
+		stack = stack[:len(stack)-1]
+		state[id] = visited
+
+		if id != r.ResourceId {
+			res.Resources = append(res.Resources, dep)
+		}
+	}
+
+	state[r.ResourceId] = visiting
+	stack = append(stack, r.ResourceId)
+	for _, id := range r.Dependencies {
+		visit(id)
+	}
+
+	return res
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"carbon/domain"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// customField returns the values of a XenForo custom field. Text fields are
+// split on commas, semicolons and line breaks, while choice fields come back
+// as a list or a map of the selected options.
+func customField(r *domain.Resource, id string) []string {
+	fields, ok := r.CustomFields.(map[string]interface{})
+	if !ok || id == "" {
+		return nil
+	}
+
+	var values []string
+	switch v := fields[id].(type) {
+	case string:
+		values = strings.FieldsFunc(v, func(r rune) bool {
+			return r == ',' || r == ';' || r == '\n' || r == '\r'
+		})
+	case []interface{}:
+		for _, e := range v {
+			values = append(values, fmt.Sprint(e))
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		values = keys
+	}
+
+	res := values[:0]
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// resourceUrlPattern matches the ID at the end of a XenForo resource URL such
+// as https://forum.rigsofrods.org/resources/some-truck.123/.
+var resourceUrlPattern = regexp.MustCompile(`/resources/(?:[^/]*\.)?(\d+)/?(?:[?#].*)?$`)
+
+// parseDependencies extracts the resource IDs listed in the dependency field.
+// Entries can be plain IDs or resource URLs. Anything else, duplicates and
+// references to the resource itself are ignored.
+func parseDependencies(r *domain.Resource, field string) []int {
+	seen := make(map[int]bool)
+	var deps []int
+	for _, v := range customField(r, field) {
+		for _, token := range strings.Fields(v) {
+			id, err := strconv.Atoi(strings.TrimPrefix(token, "#"))
+			if err != nil {
+				m := resourceUrlPattern.FindStringSubmatch(token)
+				if m == nil {
+					continue
+				}
+				id, _ = strconv.Atoi(m[1])
+			}
+			if id <= 0 || id == r.ResourceId || seen[id] {
+				continue
+			}
+			seen[id] = true
+			deps = append(deps, id)
+		}
+	}
+	return deps
+}
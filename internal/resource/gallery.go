@@ -0,0 +1,58 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"carbon/domain"
+	"context"
+)
+
+// Gallery returns the images attached to the description and the updates of
+// the resource, in the order they were posted in. Like the updates, the
+// gallery is cached until the resource is updated again.
+func (m *Manager) Gallery(ctx context.Context, r *domain.Resource) ([]domain.GalleryImage, error) {
+	if v, ok := m.gallery.get(r.ResourceId, r.LastUpdate); ok {
+		return v, nil
+	}
+
+	posts, err := m.client.GetResourceAttachments(ctx, r.ID(), r.DescriptionUpdateId)
+	if err != nil {
+		return nil, err
+	}
+
+	images := []domain.GalleryImage{}
+	for _, p := range posts {
+		for _, a := range p.Attachments {
+			// Only images have dimensions, anything else attached to a post
+			// cannot be previewed.
+			if a.IsVideo || a.Width == 0 || a.Height == 0 {
+				continue
+			}
+			images = append(images, domain.GalleryImage{
+				AttachmentId:     a.AttachmentId,
+				ResourceUpdateId: p.ResourceUpdateId,
+				Filename:         a.Filename,
+				Width:            a.Width,
+				Height:           a.Height,
+				ThumbnailUrl:     a.ThumbnailUrl,
+				Url:              a.DirectUrl,
+			})
+		}
+	}
+
+	m.gallery.put(r.ResourceId, r.LastUpdate, images)
+	return images, nil
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"time"
+)
+
+// generation identifies a version of a cached collection. It is derived from
+// the content itself, so a refresh that returns identical data keeps the
+// same generation and the value stays stable across restarts.
+type generation struct {
+	value    uint64
+	modified time.Time
+}
+
+// update recomputes the generation for v and returns true if it changed.
+func (g *generation) update(v interface{}, now time.Time) bool {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+
+	h := fnv.New64a()
+	h.Write(b)
+	if sum := h.Sum64(); sum != g.value || g.modified.IsZero() {
+		g.value = sum
+		g.modified = now
+		return true
+	}
+	return false
+}
+
+// Generation returns the generation of the resource collection and the time
+// it last changed.
+func (m *Manager) Generation() (uint64, time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.generation.value, m.generation.modified
+}
+
+// CategoryGeneration returns the generation of the category collection and
+// the time it last changed.
+func (m *Manager) CategoryGeneration() (uint64, time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.categoryGeneration.value, m.categoryGeneration.modified
+}
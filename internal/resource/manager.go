@@ -16,42 +16,177 @@
 package resource
 
 import (
+	"carbon/config"
 	"carbon/domain"
 	"carbon/remote"
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/apex/log"
+	"github.com/cenkalti/backoff/v4"
 )
 
 type Manager struct {
-	mu        sync.RWMutex
-	resources []*domain.Resource
-	client    remote.Client
+	mu         sync.RWMutex
+	resources  []*domain.Resource
+	categories []*domain.ResourceCategory
+	client     remote.Client
+
+	updates      *stampedCache[[]domain.ResourceUpdate]
+	versions     *stampedCache[[]domain.ResourceVersion]
+	reviews      *stampedCache[[]domain.ResourceReview]
+	gallery      *stampedCache[[]domain.GalleryImage]
+	versionIndex map[uint]domain.ResourceVersion
+
+	generation         generation
+	categoryGeneration generation
+
+	changes *changeLog
+
+	hooks []RefreshFunc
+
+	// refreshMu serializes refreshes, so that two of them never see the
+	// same previous collection, write the snapshot at the same time or run
+	// the hooks twice for the same change.
+	refreshMu sync.Mutex
+
+	// restored holds the resources loaded from the snapshot, which hooks
+	// registered after the first refresh can compare against.
+	restored []*domain.Resource
+
+	// degraded is set while the cache is being served from a snapshot on
+	// disk because the remote API could not be reached.
+	degraded bool
 }
 
+// NewManager returns a resource manager with a populated cache. The last
+// snapshot on disk is always loaded first so that the change feed carries on
+// where it left off. If the remote API is then unreachable the snapshot is
+// served as is and the remote is retried in the background. An error is only
+// returned if neither source is available.
 func NewManager(ctx context.Context, client remote.Client) (*Manager, error) {
-	m := &Manager{client: client}
+	m := &Manager{
+		client:       client,
+		updates:      newStampedCache[[]domain.ResourceUpdate](),
+		versions:     newStampedCache[[]domain.ResourceVersion](),
+		reviews:      newStampedCache[[]domain.ResourceReview](),
+		gallery:      newStampedCache[[]domain.GalleryImage](),
+		versionIndex: make(map[uint]domain.ResourceVersion),
+	}
+
+	s, serr := readSnapshot()
+	if serr != nil && !errors.Is(serr, os.ErrNotExist) {
+		log.WithField("error", serr).Warn("could not load resource cache snapshot")
+	}
+	m.restore(s)
+
 	err := m.init(ctx)
-	return m, err
+	if err == nil {
+		return m, nil
+	}
+	if s == nil {
+		return m, err
+	}
+
+	log.WithFields(log.Fields{
+		"error":         err,
+		"snapshot_date": s.CreatedAt,
+	}).Warn("remote API unreachable, serving resources from snapshot")
+
+	m.mu.Lock()
+	m.degraded = true
+	m.mu.Unlock()
+
+	go m.retry(ctx)
+
+	return m, nil
 }
 
-func (m *Manager) init(ctx context.Context) error {
-	log.Info("fetching resources from remote API...")
-	resources, err := m.client.GetResources(ctx)
-	if err != nil {
-		return err
+// restore seeds the manager from a snapshot. Without one the change feed
+// starts from the current time, which keeps sequence numbers monotonic even
+// if the snapshot was lost.
+func (m *Manager) restore(s *snapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.changes = newChangeLog(uint64(time.Now().UnixMilli()))
+	if s == nil {
+		return
 	}
 
-	for _, data := range resources {
-		data := data
-		m.Add(&data)
+	m.resources = s.Resources
+	m.restored = s.Resources
+	m.categories = s.Categories
+	m.generation.update(s.Resources, s.CreatedAt)
+	m.categoryGeneration.update(s.Categories, s.CreatedAt)
+	if s.Changes != nil && s.Changes.Sequence >= s.Changes.Floor {
+		m.changes = s.Changes
 	}
+	m.changes.index(s.Resources)
+}
 
-	return nil
+// RefreshFunc is called after every successful refresh with the resources as
+// they were before and after it.
+type RefreshFunc func(ctx context.Context, prev, next []*domain.Resource)
+
+// OnRefresh registers a function to be called after every successful refresh.
+// Functions are called synchronously and in order of registration, so anything
+// slow should hand the work off to its own goroutine.
+func (m *Manager) OnRefresh(fn RefreshFunc) {
+	m.mu.Lock()
+	m.hooks = append(m.hooks, fn)
+	m.mu.Unlock()
+}
+
+// Restored returns the resources as they were in the snapshot loaded at
+// startup, or nil if there was none. Hooks are registered once the manager
+// has already been refreshed, so this is what tells them what changed while
+// carbon was not running.
+func (m *Manager) Restored() []*domain.Resource {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.restored
+}
+
+func (m *Manager) init(ctx context.Context) error {
+	log.Info("fetching resources from remote API...")
+	return m.AsyncRefreshCache(ctx)
+}
+
+// retry keeps retrying the remote API with an exponential backoff until the
+// cache has been refreshed, which takes the manager out of degraded mode.
+func (m *Manager) retry(ctx context.Context) {
+	b := backoff.NewExponentialBackOff()
+	b.MaxInterval = time.Minute * 5
+	b.MaxElapsedTime = 0
+
+	_ = backoff.RetryNotify(func() error {
+		return m.AsyncRefreshCache(ctx)
+	}, backoff.WithContext(b, ctx), func(err error, d time.Duration) {
+		log.WithFields(log.Fields{
+			"error": err,
+			"retry": d,
+		}).Warn("still unable to reach remote API, serving resources from snapshot")
+	})
+}
+
+// Degraded returns true if the cache is being served from a snapshot because
+// the remote API has not been reachable since startup.
+func (m *Manager) Degraded() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.degraded
 }
 
 func (m *Manager) AsyncRefreshCache(ctx context.Context) error {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
 	log.Info("refreshing resources cache from remote API...")
 	resources, err := m.client.GetResources(ctx)
 	// This will prevent the cache from being overwritten in case of
@@ -60,31 +195,136 @@ func (m *Manager) AsyncRefreshCache(ctx context.Context) error {
 		return err
 	}
 
+	categories, _, err := m.client.GetResourceCategories(ctx)
+	if err != nil {
+		return err
+	}
+
 	var newCache []*domain.Resource
 	for _, data := range resources {
 		data := data
+		prepare(&data)
 		newCache = append(newCache, &data)
 	}
 
+	var newCategories []*domain.ResourceCategory
+	for _, data := range categories {
+		data := data
+		newCategories = append(newCategories, &data)
+	}
+
+	prev := m.Collection()
 	m.Put(newCache)
+	m.PutCategories(newCategories)
+
+	m.mu.Lock()
+	if m.degraded {
+		log.Info("remote API reachable again, leaving degraded mode")
+	}
+	m.degraded = false
+	changes := m.changes.clone()
+	m.mu.Unlock()
+
+	if err := writeSnapshot(&snapshot{
+		CreatedAt:  time.Now(),
+		Resources:  newCache,
+		Categories: newCategories,
+		Changes:    changes,
+	}); err != nil {
+		log.WithField("error", err).Warn("failed to write resource cache snapshot")
+	}
+
+	m.mu.RLock()
+	hooks := m.hooks
+	m.mu.RUnlock()
+	for _, fn := range hooks {
+		fn(ctx, prev, newCache)
+	}
 
 	return nil
 }
 
+// prepare fills in the fields that carbon derives from the remote data.
+func prepare(r *domain.Resource) {
+	SetDownloadUrls(r.ResourceId, r.CurrentFiles)
+	r.Dependencies = parseDependencies(r, dependencyField())
+	r.GameVersions = parseGameVersions(r, gameVersionField())
+}
+
+func dependencyField() string {
+	if f := config.Get().Resources.DependencyField; f != "" {
+		return f
+	}
+	return "dependencies"
+}
+
+func gameVersionField() string {
+	if f := config.Get().Resources.GameVersionField; f != "" {
+		return f
+	}
+	return "game_versions"
+}
+
+// SetDownloadUrls points each file at carbon's authenticated download
+// endpoint for the resource.
+func SetDownloadUrls(rid int, files []domain.ResourceFile) {
+	for i := range files {
+		files[i].DownloadUrl = fmt.Sprintf("/resources/%d/files/%d/download", rid, files[i].Id)
+	}
+}
+
 // Put can replace everything in the collection, even if nothing is
 // in the collection.
 func (m *Manager) Put(r []*domain.Resource) {
 	m.mu.Lock()
 	m.resources = r
+	m.generation.update(r, time.Now())
+	m.changes.record(r)
 	m.mu.Unlock()
 }
 
 func (m *Manager) Add(r *domain.Resource) {
 	m.mu.Lock()
 	m.resources = append(m.resources, r)
+	m.generation.update(m.resources, time.Now())
+	m.changes.record(m.resources)
 	m.mu.Unlock()
 }
 
+// Refresh fetches a single resource from the remote API and replaces it in
+// the collection. It is used after carbon itself changed the resource, so
+// that the cache does not have to wait for the next full refresh.
+func (m *Manager) Refresh(ctx context.Context, rid int) (*domain.Resource, error) {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	r, err := m.client.GetResource(ctx, strconv.Itoa(rid))
+	if err != nil {
+		return nil, err
+	}
+	prepare(&r)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// The collection may be held by readers, so it is copied rather than
+	// modified in place.
+	resources := make([]*domain.Resource, 0, len(m.resources)+1)
+	found := false
+	for _, v := range m.resources {
+		if v.ResourceId == rid {
+			v, found = &r, true
+		}
+		resources = append(resources, v)
+	}
+	if !found {
+		resources = append(resources, &r)
+	}
+	m.resources = resources
+	m.generation.update(m.resources, time.Now())
+	m.changes.record(m.resources)
+	return &r, nil
+}
+
 func (m *Manager) Find(filter func(match *domain.Resource) bool) *domain.Resource {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
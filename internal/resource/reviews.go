@@ -0,0 +1,84 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"carbon/domain"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Reviews returns the reviews of the resource, newest first. Reviews do not
+// bump the resource's LastUpdate, so the cache is stamped with the review and
+// rating counters instead.
+func (m *Manager) Reviews(ctx context.Context, r *domain.Resource) ([]domain.ResourceReview, error) {
+	stamp := reviewStamp(r)
+	if v, ok := m.reviews.get(r.ResourceId, stamp); ok {
+		return v, nil
+	}
+
+	reviews, err := m.client.GetResourceReviews(ctx, r.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	// XenForo embeds the full user record of the reviewer, which may be more
+	// than we want to show. Public profiles are attached on request instead.
+	for i := range reviews {
+		reviews[i].User = nil
+	}
+	sort.Slice(reviews, func(i, j int) bool {
+		return reviews[i].RatingDate > reviews[j].RatingDate
+	})
+
+	m.reviews.put(r.ResourceId, stamp, reviews)
+	return reviews, nil
+}
+
+// InvalidateReviews drops the cached reviews of the resource.
+func (m *Manager) InvalidateReviews(rid int) {
+	m.reviews.invalidate(rid)
+}
+
+// ReviewSummary counts the visible reviews per star rating. Deleted and
+// moderated reviews are left out.
+func ReviewSummary(reviews []domain.ResourceReview) domain.ReviewSummary {
+	s := domain.ReviewSummary{
+		Histogram: map[uint]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0},
+	}
+
+	var total uint
+	for _, r := range reviews {
+		if r.RatingState != "visible" || r.Rating < 1 || r.Rating > 5 {
+			continue
+		}
+		s.Histogram[r.Rating]++
+		s.Count++
+		total += r.Rating
+	}
+	if s.Count > 0 {
+		s.Average = float64(total) / float64(s.Count)
+	}
+	return s
+}
+
+func reviewStamp(r *domain.Resource) uint {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d:%d:%g", r.ReviewCount, r.RatingCount, r.RatingAvg)
+	return uint(h.Sum64())
+}
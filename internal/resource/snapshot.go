@@ -0,0 +1,76 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"carbon/config"
+	"carbon/domain"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const snapshotFile = "resources.json"
+
+// snapshot is the on-disk representation of the resource and category
+// cache. It is only ever written after a successful refresh so that it always
+// reflects data that XenForo actually returned.
+type snapshot struct {
+	CreatedAt  time.Time                  `json:"created_at"`
+	Resources  []*domain.Resource         `json:"resources"`
+	Categories []*domain.ResourceCategory `json:"categories"`
+	Changes    *changeLog                 `json:"changes"`
+}
+
+func snapshotPath() string {
+	return filepath.Join(config.Get().RootDirectory, snapshotFile)
+}
+
+// readSnapshot loads the last snapshot written to disk.
+func readSnapshot() (*snapshot, error) {
+	b, err := os.ReadFile(snapshotPath())
+	if err != nil {
+		return nil, err
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// writeSnapshot persists the snapshot to disk. The data is written to a
+// temporary file first and then renamed so that a crash halfway through
+// never leaves a truncated snapshot behind.
+func writeSnapshot(s *snapshot) error {
+	p := snapshotPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"carbon/domain"
+	"context"
+	"sort"
+)
+
+// Updates returns the update posts of the resource, newest first. The posts
+// are fetched from the remote API once and then served from the cache until
+// the resource is updated again.
+func (m *Manager) Updates(ctx context.Context, r *domain.Resource) ([]domain.ResourceUpdate, error) {
+	if v, ok := m.updates.get(r.ResourceId, r.LastUpdate); ok {
+		return v, nil
+	}
+
+	updates, err := m.client.GetResourceUpdates(ctx, r.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	// Pages are fetched concurrently so they may arrive in any order.
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].PostDate > updates[j].PostDate
+	})
+
+	m.updates.put(r.ResourceId, r.LastUpdate, updates)
+	return updates, nil
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import (
+	"carbon/domain"
+	"context"
+	"sort"
+	"strconv"
+)
+
+// Versions returns the versions of the resource, newest first. They are
+// fetched from the remote API once and then served from the cache until the
+// resource is updated again.
+func (m *Manager) Versions(ctx context.Context, r *domain.Resource) ([]domain.ResourceVersion, error) {
+	if v, ok := m.versions.get(r.ResourceId, r.LastUpdate); ok {
+		return v, nil
+	}
+
+	versions, err := m.client.GetResourceVersions(ctx, r.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].ReleaseDate > versions[j].ReleaseDate
+	})
+	for _, v := range versions {
+		SetDownloadUrls(r.ResourceId, v.Files)
+	}
+
+	m.versions.put(r.ResourceId, r.LastUpdate, versions)
+
+	m.mu.Lock()
+	for _, v := range versions {
+		m.versionIndex[v.ResourceVersionId] = v
+	}
+	m.mu.Unlock()
+
+	return versions, nil
+}
+
+// Version returns a single version by its ID. Versions seen through Versions
+// are served from the cache, anything else is fetched from the remote API.
+func (m *Manager) Version(ctx context.Context, vid uint) (domain.ResourceVersion, error) {
+	m.mu.RLock()
+	v, ok := m.versionIndex[vid]
+	m.mu.RUnlock()
+	if ok {
+		return v, nil
+	}
+
+	v, err := m.client.GetResourceVersion(ctx, strconv.FormatUint(uint64(vid), 10))
+	if err != nil {
+		return domain.ResourceVersion{}, err
+	}
+	SetDownloadUrls(int(v.ResourceId), v.Files)
+
+	m.mu.Lock()
+	m.versionIndex[vid] = v
+	m.mu.Unlock()
+
+	return v, nil
+}
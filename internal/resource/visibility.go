@@ -0,0 +1,40 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resource
+
+import "carbon/domain"
+
+const (
+	StateVisible   = "visible"
+	StateModerated = "moderated"
+	StateDeleted   = "deleted"
+)
+
+// Visible returns true if the viewer is allowed to see the resource. Anyone
+// can see visible resources, authors can also see their own resources
+// awaiting moderation and staff can see everything. The viewer is nil for
+// anonymous requests.
+func Visible(r *domain.Resource, viewer *domain.User) bool {
+	switch {
+	case r.ResourceState == StateVisible:
+		return true
+	case viewer == nil:
+		return false
+	case viewer.IsStaff:
+		return true
+	}
+	return r.ResourceState == StateModerated && r.UserId == viewer.UserID
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package signing
+
+import (
+	"carbon/config"
+	"carbon/domain"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/apex/log"
+)
+
+const Algorithm = "Ed25519"
+
+var (
+	ErrMissingKeyId = errors.New("signing: a key id is required to sign responses")
+	ErrInvalidKey   = errors.New("signing: private key is not a PEM encoded Ed25519 key")
+)
+
+// Manager signs responses with the Ed25519 key from the configuration and
+// lists the public keys clients can verify them with. Signing is disabled
+// when no key is configured.
+type Manager struct {
+	keyId   string
+	key     ed25519.PrivateKey
+	retired []domain.SigningKey
+}
+
+func NewManager(ctx context.Context) (*Manager, error) {
+	cfg := config.Get().Signing
+	m := &Manager{}
+
+	seen := make(map[string]bool)
+	if cfg.PrivateKeyFile != "" {
+		if cfg.KeyId == "" {
+			return m, ErrMissingKeyId
+		}
+		key, err := readPrivateKey(cfg.PrivateKeyFile)
+		if err != nil {
+			return m, err
+		}
+		m.keyId, m.key = cfg.KeyId, key
+		seen[cfg.KeyId] = true
+	}
+
+	for _, k := range cfg.RetiredKeys {
+		b, err := base64.StdEncoding.DecodeString(k.PublicKey)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return m, fmt.Errorf("signing: retired key %q is not a base64 encoded Ed25519 public key", k.KeyId)
+		}
+		if k.KeyId == "" || seen[k.KeyId] {
+			return m, fmt.Errorf("signing: retired key id %q is empty or already in use", k.KeyId)
+		}
+		seen[k.KeyId] = true
+		m.retired = append(m.retired, domain.SigningKey{
+			KeyId:     k.KeyId,
+			Algorithm: Algorithm,
+			PublicKey: k.PublicKey,
+			Status:    domain.SigningKeyRetired,
+		})
+	}
+
+	if m.Enabled() {
+		log.WithField("key_id", m.keyId).Info("signing manifest responses")
+	}
+	return m, nil
+}
+
+func readPrivateKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	k, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	return k, nil
+}
+
+// Enabled returns true if responses are signed.
+func (m *Manager) Enabled() bool {
+	return m.key != nil
+}
+
+// Sign signs the message with the current key and returns the id of the key
+// along with the base64 encoded signature.
+func (m *Manager) Sign(message []byte) (string, string) {
+	return m.keyId, base64.StdEncoding.EncodeToString(ed25519.Sign(m.key, message))
+}
+
+// Keys returns the current public key, if any, followed by the retired ones.
+func (m *Manager) Keys() []domain.SigningKey {
+	keys := make([]domain.SigningKey, 0, len(m.retired)+1)
+	if m.Enabled() {
+		keys = append(keys, domain.SigningKey{
+			KeyId:     m.keyId,
+			Algorithm: Algorithm,
+			PublicKey: base64.StdEncoding.EncodeToString(m.key.Public().(ed25519.PublicKey)),
+			Status:    domain.SigningKeyCurrent,
+		})
+	}
+	return append(keys, m.retired...)
+}
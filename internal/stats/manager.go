@@ -0,0 +1,352 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package stats
+
+import (
+	"carbon/config"
+	"carbon/domain"
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"gorm.io/gorm"
+)
+
+// Window is a period of time over which resources are ranked. Activity is
+// weighted down by half every HalfLife when computing trending scores, so that
+// a resource picking up right now ranks above one that peaked days ago.
+type Window struct {
+	Length   time.Duration
+	HalfLife time.Duration
+}
+
+var Windows = map[string]Window{
+	"24h": {Length: 24 * time.Hour, HalfLife: 6 * time.Hour},
+	"7d":  {Length: 7 * 24 * time.Hour, HalfLife: 42 * time.Hour},
+	"30d": {Length: 30 * 24 * time.Hour, HalfLife: 180 * time.Hour},
+}
+
+// viewWeight is how much a view counts towards the trending score compared
+// to a download.
+const viewWeight = 0.05
+
+// retention is how long snapshots are kept for. It has to cover the longest
+// window.
+const retention = 31 * 24 * time.Hour
+
+type counters struct {
+	downloads uint
+	views     uint
+	at        time.Time
+}
+
+// Manager records the download and view counters of every resource over time
+// and ranks resources by how much those counters grew recently. XenForo only
+// exposes lifetime totals, which would always favour the oldest resources.
+type Manager struct {
+	db *gorm.DB
+
+	// ready is closed once the first collection has been recorded, there is
+	// nothing to rank before that.
+	ready chan struct{}
+	once  sync.Once
+
+	mu        sync.RWMutex
+	last      map[int]counters
+	resources []*domain.Resource
+	trending  map[string][]domain.ResourceScore
+	popular   map[string][]domain.ResourceScore
+	computed  time.Time
+}
+
+// NewManager returns a stats manager. The rankings are computed in the
+// background, every configured interval, from what the refreshes recorded.
+func NewManager(ctx context.Context, db *gorm.DB) (*Manager, error) {
+	m := &Manager{
+		db:    db,
+		ready: make(chan struct{}),
+		last:  make(map[int]counters),
+	}
+	if err := m.init(); err != nil {
+		return m, err
+	}
+
+	go m.work(ctx)
+
+	return m, nil
+}
+
+func (m *Manager) init() error {
+	log.Info("initializing resource stats schema into the database...")
+
+	if err := m.db.AutoMigrate(&domain.ResourceStat{}); err != nil {
+		return err
+	}
+
+	var rows []domain.ResourceStat
+	latest := m.db.Model(&domain.ResourceStat{}).Select("MAX(id)").Group("resource_id")
+	if err := m.db.Where("id IN (?)", latest).Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, r := range rows {
+		m.last[r.ResourceId] = counters{downloads: r.DownloadCount, views: r.ViewCount, at: r.CreatedAt}
+	}
+
+	return nil
+}
+
+func interval() time.Duration {
+	if v := config.Get().Stats.Interval; v > 0 {
+		return v * time.Minute
+	}
+	return 15 * time.Minute
+}
+
+// work computes the rankings as soon as resources are recorded and then again every interval, so
+// that the history is never scanned from within a refresh.
+func (m *Manager) work(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-m.ready:
+	}
+
+	t := time.NewTicker(interval())
+	defer t.Stop()
+
+	for {
+		m.mu.RLock()
+		resources := m.resources
+		m.mu.RUnlock()
+
+		now := time.Now()
+		if err := m.purge(ctx, now); err != nil {
+			log.WithField("error", err).Warn("failed to purge resource stats")
+		}
+		if err := m.compute(ctx, now, resources); err != nil {
+			log.WithField("error", err).Warn("failed to compute resource rankings")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Record is meant to be registered as a resource refresh hook. The counters
+// of every resource are snapshotted on each refresh, except those that have
+// not changed since the last snapshot: an identical row adds nothing since
+// growth is measured between snapshots.
+func (m *Manager) Record(ctx context.Context, _, next []*domain.Resource) {
+	if err := m.snapshot(ctx, time.Now(), next); err != nil {
+		log.WithField("error", err).Warn("failed to record resource stats")
+	}
+
+	m.mu.Lock()
+	m.resources = next
+	m.mu.Unlock()
+
+	m.once.Do(func() { close(m.ready) })
+}
+
+func (m *Manager) snapshot(ctx context.Context, now time.Time, resources []*domain.Resource) error {
+	m.mu.RLock()
+	var rows []domain.ResourceStat
+	for _, r := range resources {
+		c, ok := m.last[r.ResourceId]
+		if ok && c.downloads == r.DownloadCount && c.views == r.ViewCount {
+			continue
+		}
+		rows = append(rows, domain.ResourceStat{
+			ResourceId:    r.ResourceId,
+			DownloadCount: r.DownloadCount,
+			ViewCount:     r.ViewCount,
+			CreatedAt:     now,
+		})
+	}
+	m.mu.RUnlock()
+
+	if len(rows) == 0 {
+		return nil
+	}
+	if err := m.db.WithContext(ctx).CreateInBatches(rows, 500).Error; err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	for _, r := range rows {
+		m.last[r.ResourceId] = counters{downloads: r.DownloadCount, views: r.ViewCount, at: now}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// purge removes snapshots that fell out of every window. The latest snapshot
+// of each resource is always kept, since it is the baseline the next change
+// is measured against.
+func (m *Manager) purge(ctx context.Context, now time.Time) error {
+	db := m.db.WithContext(ctx)
+
+	var keep []uint
+	if err := db.Model(&domain.ResourceStat{}).Group("resource_id").Pluck("MAX(id)", &keep).Error; err != nil {
+		return err
+	}
+
+	q := db.Where("created_at < ?", now.Add(-retention))
+	if len(keep) > 0 {
+		q = q.Where("id NOT IN ?", keep)
+	}
+	return q.Delete(&domain.ResourceStat{}).Error
+}
+
+// compute ranks the resources over every window. The history of the longest
+// window is read once and shared by all of them.
+func (m *Manager) compute(ctx context.Context, now time.Time, resources []*domain.Resource) error {
+	var longest time.Duration
+	for _, w := range Windows {
+		longest = max(longest, w.Length)
+	}
+	history, err := m.history(ctx, now.Add(-longest))
+	if err != nil {
+		return err
+	}
+
+	trending := make(map[string][]domain.ResourceScore, len(Windows))
+	popular := make(map[string][]domain.ResourceScore, len(Windows))
+
+	for name, w := range Windows {
+		scores := scores(now, w, history, resources)
+
+		t := make([]domain.ResourceScore, len(scores))
+		copy(t, scores)
+		sort.SliceStable(t, func(i, j int) bool {
+			return t[i].Score > t[j].Score
+		})
+		trending[name] = t
+
+		p := make([]domain.ResourceScore, len(scores))
+		copy(p, scores)
+		sort.SliceStable(p, func(i, j int) bool {
+			if p[i].Downloads != p[j].Downloads {
+				return p[i].Downloads > p[j].Downloads
+			}
+			return p[i].Views > p[j].Views
+		})
+		popular[name] = p
+	}
+
+	m.mu.Lock()
+	m.trending = trending
+	m.popular = popular
+	m.computed = now
+	m.mu.Unlock()
+	return nil
+}
+
+// history returns the snapshots of every resource since start, preceded by
+// the last snapshot before it, grouped by resource and oldest first.
+func (m *Manager) history(ctx context.Context, start time.Time) (map[int][]counters, error) {
+	db := m.db.WithContext(ctx)
+
+	var before []domain.ResourceStat
+	latest := db.Model(&domain.ResourceStat{}).Select("MAX(id)").Where("created_at < ?", start).Group("resource_id")
+	if err := db.Where("id IN (?)", latest).Find(&before).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []domain.ResourceStat
+	if err := db.Where("created_at >= ?", start).Order("resource_id, created_at").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	history := make(map[int][]counters)
+	for _, r := range append(before, rows...) {
+		history[r.ResourceId] = append(history[r.ResourceId], counters{downloads: r.DownloadCount, views: r.ViewCount, at: r.CreatedAt})
+	}
+	return history, nil
+}
+
+// scores measures how much the counters of every resource grew within the
+// window. The growth between two snapshots is attributed to the later one,
+// and measured from the last snapshot before the window.
+func scores(now time.Time, w Window, history map[int][]counters, resources []*domain.Resource) []domain.ResourceScore {
+	start := now.Add(-w.Length)
+
+	var res []domain.ResourceScore
+	for _, r := range resources {
+		s := domain.ResourceScore{ResourceId: r.ResourceId}
+
+		// Resources released within the window have no baseline,
+		// everything they have counts as growth.
+		var prev counters
+		ok := false
+		if released := time.Unix(int64(r.ResourceDate), 0); released.After(start) {
+			prev, ok = counters{at: released}, true
+		}
+
+		for _, cur := range history[r.ResourceId] {
+			if cur.at.Before(start) {
+				prev, ok = cur, true
+				continue
+			}
+			if !ok {
+				prev, ok = cur, true
+				continue
+			}
+
+			// Counters can go down when XenForo rebuilds them.
+			var downloads, views uint
+			if cur.downloads > prev.downloads {
+				downloads = cur.downloads - prev.downloads
+			}
+			if cur.views > prev.views {
+				views = cur.views - prev.views
+			}
+
+			decay := math.Exp2(-now.Sub(cur.at).Hours() / w.HalfLife.Hours())
+			s.Score += (float64(downloads) + viewWeight*float64(views)) * decay
+			s.Downloads += downloads
+			s.Views += views
+			prev = cur
+		}
+
+		if s.Downloads > 0 || s.Views > 0 {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// Trending returns the resources ranked by their decayed activity within the
+// window, along with when the ranking was computed.
+func (m *Manager) Trending(window string) ([]domain.ResourceScore, time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.trending[window], m.computed
+}
+
+// Popular returns the resources ranked by the number of downloads gained
+// within the window, along with when the ranking was computed.
+func (m *Manager) Popular(window string) ([]domain.ResourceScore, time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.popular[window], m.computed
+}
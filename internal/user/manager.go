@@ -16,15 +16,55 @@
 package user
 
 import (
+	"carbon/domain"
 	"carbon/remote"
 	"context"
+	"sync"
+	"time"
 )
 
+// profileTtl is how long a public profile is served from the cache before it
+// is fetched again.
+const profileTtl = 15 * time.Minute
+
 type Manager struct {
 	client remote.Client
+
+	mu       sync.Mutex
+	profiles map[int]profile
+}
+
+type profile struct {
+	user    domain.User
+	expires time.Time
 }
 
 func NewManager(ctx context.Context, client remote.Client) (*Manager, error) {
-	m := &Manager{client: client}
+	m := &Manager{
+		client:   client,
+		profiles: make(map[int]profile),
+	}
 	return m, nil
 }
+
+// Profile returns the public profile of a user. Private details such as the
+// email address are removed before the profile is cached.
+func (m *Manager) Profile(ctx context.Context, uid int) (domain.User, error) {
+	m.mu.Lock()
+	p, ok := m.profiles[uid]
+	m.mu.Unlock()
+	if ok && time.Now().Before(p.expires) {
+		return p.user, nil
+	}
+
+	u, err := m.client.GetUser(ctx, uid)
+	if err != nil {
+		return domain.User{}, err
+	}
+	u.Email = ""
+
+	m.mu.Lock()
+	m.profiles[uid] = profile{user: u, expires: time.Now().Add(profileTtl)}
+	m.mu.Unlock()
+	return u, nil
+}
@@ -0,0 +1,238 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package version compares the free-form version strings authors put on
+// their resources, such as "v1.2", "1.2.1 beta", "2023.05" or "1.0a".
+package version
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Qualifiers that mark a version as coming before the release it names.
+var preRelease = map[string]int{
+	"dev":      -5,
+	"snapshot": -5,
+	"nightly":  -5,
+	"wip":      -5,
+	"test":     -4,
+	"alpha":    -3,
+	"beta":     -2,
+	"pre":      -1,
+	"preview":  -1,
+	"rc":       -1,
+}
+
+// Qualifiers that mark a version as coming after the release it names.
+var postRelease = map[string]int{
+	"post":   1,
+	"patch":  1,
+	"fix":    1,
+	"hotfix": 1,
+	"update": 1,
+}
+
+// Words that are commonly put in front of the version number and carry no
+// meaning of their own.
+var prefixes = map[string]bool{
+	"v":       true,
+	"ver":     true,
+	"version": true,
+	"release": true,
+	"rev":     true,
+	"r":       true,
+	"build":   true,
+}
+
+type token struct {
+	number string // digits without leading zeros, empty for qualifiers
+	rank   int    // ordering of a qualifier relative to the release
+}
+
+// Version is a parsed version string.
+type Version struct {
+	raw    string
+	tokens []token
+}
+
+// Parse splits a version string into numbers and qualifiers. Separators are
+// ignored, so "1.2-beta", "1.2 beta" and "1.2beta" are all the same version.
+// A single letter directly following a number, as in "1.0a", is treated as a
+// revision of that release and sorts after it. Unknown words are ignored.
+func Parse(s string) Version {
+	v := Version{raw: strings.ToLower(strings.TrimSpace(s))}
+
+	var runs []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			runs = append(runs, string(cur))
+			cur = cur[:0]
+		}
+	}
+	for _, r := range v.raw {
+		switch {
+		case unicode.IsDigit(r):
+			if len(cur) > 0 && !unicode.IsDigit(cur[0]) {
+				flush()
+			}
+			cur = append(cur, r)
+		case unicode.IsLetter(r):
+			if len(cur) > 0 && unicode.IsDigit(cur[0]) {
+				flush()
+				// Mark letters glued to a number so "1.0a" can be told
+				// apart from "1.0 a".
+				cur = append(cur, '+')
+			}
+			cur = append(cur, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	seenNumber := false
+	for _, run := range runs {
+		if unicode.IsDigit(rune(run[0])) {
+			n := strings.TrimLeft(run, "0")
+			if n == "" {
+				n = "0"
+			}
+			v.tokens = append(v.tokens, token{number: n})
+			seenNumber = true
+			continue
+		}
+
+		glued := strings.HasPrefix(run, "+")
+		word := strings.TrimPrefix(run, "+")
+		if !seenNumber && prefixes[word] {
+			continue
+		}
+		if rank, ok := preRelease[word]; ok {
+			v.tokens = append(v.tokens, token{rank: rank})
+			continue
+		}
+		if rank, ok := postRelease[word]; ok {
+			v.tokens = append(v.tokens, token{rank: rank})
+			continue
+		}
+		if glued && len(word) == 1 {
+			v.tokens = append(v.tokens, token{rank: 1 + int(word[0]-'a')})
+		}
+	}
+	return v
+}
+
+// Valid returns true if the version contains at least one number. Versions
+// without any number, such as "final", cannot be ordered meaningfully.
+func (v Version) Valid() bool {
+	for _, t := range v.tokens {
+		if t.number != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the normalized version string.
+func (v Version) String() string {
+	return v.raw
+}
+
+// HasPrefix returns true if v is o or a more specific version of it, such as
+// "2022.12.1" for "2022.12". Qualifiers count, so "2022.12-dev" is not a more
+// specific "2022.12".
+func (v Version) HasPrefix(o Version) bool {
+	if len(o.tokens) > len(v.tokens) {
+		return v.Compare(o) == 0
+	}
+	for i, t := range o.tokens {
+		if v.tokens[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare returns -1 if a is older than b, 1 if it is newer and 0 if both are
+// the same version. Trailing zeros are not significant, so "1.0" equals
+// "1.0.0".
+func Compare(a, b string) int {
+	return Parse(a).Compare(Parse(b))
+}
+
+func (v Version) Compare(o Version) int {
+	n := len(v.tokens)
+	if len(o.tokens) > n {
+		n = len(o.tokens)
+	}
+	for i := 0; i < n; i++ {
+		if c := compareTokens(tokenAt(v.tokens, i), tokenAt(o.tokens, i)); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// tokenAt pads the shorter version with zeros so that a missing number
+// compares equal to zero and is newer than any pre-release qualifier.
+func tokenAt(tokens []token, i int) token {
+	if i < len(tokens) {
+		return tokens[i]
+	}
+	return token{number: "0"}
+}
+
+func compareTokens(a, b token) int {
+	switch {
+	case a.number != "" && b.number != "":
+		return compareNumbers(a.number, b.number)
+	case a.number != "":
+		// A number against a qualifier: the number wins against a
+		// pre-release and loses against a post-release qualifier, unless
+		// it is a padding zero in which case the qualifier decides.
+		if a.number == "0" {
+			return sign(-b.rank)
+		}
+		return 1
+	case b.number != "":
+		if b.number == "0" {
+			return sign(a.rank)
+		}
+		return -1
+	default:
+		return sign(a.rank - b.rank)
+	}
+}
+
+// compareNumbers compares two digit strings of arbitrary length without
+// leading zeros.
+func compareNumbers(a, b string) int {
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
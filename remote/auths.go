@@ -40,6 +40,22 @@ func (c *client) ValidateUserAuthCredentials(ctx context.Context, data interface
 	return rawData, nil
 }
 
+type contextKey string
+
+const userContextKey contextKey = "xf_api_user"
+
+// WithUser returns a context that makes requests to the remote API on behalf
+// of the given user rather than as the super user the API key belongs to.
+// Permission checks such as CanDownload are then evaluated for that user.
+func WithUser(ctx context.Context, uid int) context.Context {
+	return context.WithValue(ctx, userContextKey, uid)
+}
+
+func userFromContext(ctx context.Context) (int, bool) {
+	uid, ok := ctx.Value(userContextKey).(int)
+	return uid, ok
+}
+
 // extractAuthorization will extract the proper heads passed down from upstream in the context.
 func extractAuthorization(ctx context.Context) string {
 	token, ok := ctx.Value("Authorization").(string)
@@ -25,6 +25,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"emperror.dev/errors"
@@ -37,10 +38,18 @@ type Client interface {
 	GetResources(ctx context.Context) ([]domain.Resource, error)
 	GetResource(ctx context.Context, rid string) (domain.Resource, error)
 	GetResourceCategories(ctx context.Context) ([]domain.ResourceCategory, TreeMap, error)
-	GetResourceCategory(ctx context.Context) (domain.ResourceCategory, error)
+	GetResourceCategory(ctx context.Context, cid string) (domain.ResourceCategory, error)
 	GetResourceReviews(ctx context.Context, rid string) ([]domain.ResourceReview, error)
+	GetResourceUpdates(ctx context.Context, rid string) ([]domain.ResourceUpdate, error)
+	GetResourceUpdate(ctx context.Context, id int) (domain.ResourceUpdate, error)
+	GetResourceAttachments(ctx context.Context, rid string, descriptionUpdateId int) ([]domain.ResourceUpdate, error)
 	GetResourceVersions(ctx context.Context, rid string) ([]domain.ResourceVersion, error)
 	GetResourceVersion(ctx context.Context, vid string) (domain.ResourceVersion, error)
+	GetResourceFile(ctx context.Context, fid uint, headers http.Header) (*Response, error)
+	GetResourceReview(ctx context.Context, id uint) (domain.ResourceReview, error)
+	CreateResourceReview(ctx context.Context, rid string, rating uint, message string) (domain.ResourceReview, error)
+	UpdateResourceReview(ctx context.Context, id uint, rating uint, message string) (domain.ResourceReview, error)
+	DeleteResourceReview(ctx context.Context, id uint, reason string) error
 	GetUser(ctx context.Context, uid int) (domain.User, error)
 	GetServers(ctx context.Context) ([]domain.Server, error)
 	CreateServer(ctx context.Context, server domain.Server) (domain.Server, error)
@@ -48,10 +57,13 @@ type Client interface {
 }
 
 type client struct {
-	httpClient  *http.Client
-	baseUrl     string
-	key         string
-	maxAttempts int
+	httpClient *http.Client
+	// streamClient is used for requests whose body is streamed back to the
+	// user, which can take far longer than any regular API call.
+	streamClient *http.Client
+	baseUrl      string
+	key          string
+	maxAttempts  int
 }
 
 // NewClient will return a new HTTP request client that is used for making
@@ -62,6 +74,12 @@ func NewClient(base string, key string) Client {
 		httpClient: &http.Client{
 			Timeout: time.Second * 15,
 		},
+		streamClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: time.Second * 15,
+			},
+		},
 		key:         key,
 		maxAttempts: 0,
 	}
@@ -84,8 +102,47 @@ func (c *client) Post(ctx context.Context, path string, body url.Values, headers
 	return c.requestWithRetries(ctx, http.MethodPost, path, bytes.NewBufferString(body.Encode()), headers)
 }
 
+// Delete will make an HTTP DELETE request.
+func (c *client) Delete(ctx context.Context, path string, body url.Values, headers q) (*Response, error) {
+	return c.requestWithRetries(ctx, http.MethodDelete, path, bytes.NewBufferString(body.Encode()), headers)
+}
+
+// Stream will make a single HTTP GET request without an overall timeout and
+// without asking for a compressed response, so that the body can be handed
+// to the user as is. A 304 response is not treated as an error. The caller
+// is responsible for closing the body.
+func (c *client) Stream(ctx context.Context, path string, headers q) (*Response, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+
+	res, err := c.streamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	r := &Response{res}
+	if r.HasError() && r.StatusCode != http.StatusNotModified {
+		defer r.Body.Close()
+		return nil, r.Error()
+	}
+	return r, nil
+}
+
 // request will make an HTTP request and execute it once with our required headers.
 func (c *client) request(ctx context.Context, method string, path string, body io.Reader, headers q, opts ...func(r *http.Request)) (*Response, error) {
+	req, err := c.newRequest(ctx, method, path, body, headers, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.httpClient.Do(req)
+	return &Response{res}, err
+}
+
+// newRequest will create an HTTP request with our required headers.
+func (c *client) newRequest(ctx context.Context, method string, path string, body io.Reader, headers q, opts ...func(r *http.Request)) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, body)
 	if err != nil {
 		return nil, err
@@ -96,6 +153,10 @@ func (c *client) request(ctx context.Context, method string, path string, body i
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded") // https://xenforo.com/docs/dev/rest-api/#accessing-the-api
 	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
 	req.Header.Set("XF-Api-Key", c.key) // We assume that `XF-Api-Key` is a super user key
+	if uid, ok := userFromContext(ctx); ok {
+		// https://xenforo.com/docs/dev/rest-api/#api-keys
+		req.Header.Set("XF-Api-User", strconv.Itoa(uid))
+	}
 
 	for k, v := range headers {
 		req.Header.Set(k, v)
@@ -107,8 +168,7 @@ func (c *client) request(ctx context.Context, method string, path string, body i
 
 	logHttpRequests(req)
 
-	res, err := c.httpClient.Do(req)
-	return &Response{res}, err
+	return req, nil
 }
 
 // requestWithRetries will make an HTTP request against the API using an exponential
@@ -205,17 +265,22 @@ func (r *Response) Error() error {
 }
 
 // Read will read the response body of the HTTP request. We will try to read
-// as if the the body was compressed.
+// as if the the body was compressed, and fall back to the raw body when it is
+// not, such as for error responses to streamed requests.
 func (r *Response) Read() ([]byte, error) {
 	var b []byte
 	if r.Response == nil {
 		return nil, errors.New("remote: attempting to read missing response")
 	}
 	if r.Response.Body != nil {
+		b, _ = io.ReadAll(r.Response.Body)
 		// Read the compressed body and pass it off to be read.
-		d, _ := gzip.NewReader(r.Response.Body)
-		defer d.Close()
-		b, _ = io.ReadAll(d)
+		if d, err := gzip.NewReader(bytes.NewReader(b)); err == nil {
+			if u, err := io.ReadAll(d); err == nil {
+				b = u
+			}
+			d.Close()
+		}
 	}
 	r.Response.Body = io.NopCloser(bytes.NewBuffer(b))
 	return b, nil
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"strconv"
 	"sync"
 
@@ -80,7 +81,7 @@ func (c *client) GetResource(ctx context.Context, rid string) (domain.Resource,
 func (c *client) GetResourceCategories(ctx context.Context) ([]domain.ResourceCategory, TreeMap, error) {
 	var r struct {
 		Data []domain.ResourceCategory `json:"categories"`
-		//Meta TreeMap                   `json:"tree_map"`
+		Meta TreeMap                   `json:"tree_map"`
 	}
 
 	res, err := c.Get(ctx, "/resource-categories", nil, nil)
@@ -96,7 +97,7 @@ func (c *client) GetResourceCategories(ctx context.Context) ([]domain.ResourceCa
 	if err := res.BindJSON(&r); err != nil {
 		return nil, nil, err
 	}
-	return r.Data, nil, nil
+	return r.Data, r.Meta, nil
 }
 
 func (c *client) GetResourceReviews(ctx context.Context, rid string) ([]domain.ResourceReview, error) {
@@ -127,6 +128,96 @@ func (c *client) GetResourceReviews(ctx context.Context, rid string) ([]domain.R
 	return reviews, nil
 }
 
+func (c *client) GetResourceUpdates(ctx context.Context, rid string) ([]domain.ResourceUpdate, error) {
+	updates, meta, err := c.getResourceUpdatesPaged(ctx, 1, rid)
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	if meta.LastPage > 1 {
+		g, ctx := errgroup.WithContext(ctx)
+		for page := meta.CurrentPage + 1; page <= meta.LastPage; page++ {
+			page := page
+			g.Go(func() error {
+				p, _, err := c.getResourceUpdatesPaged(ctx, int(page), rid)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				updates = append(updates, p...)
+				mu.Unlock()
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+	}
+	return updates, nil
+}
+
+func (c *client) GetResourceUpdate(ctx context.Context, id int) (domain.ResourceUpdate, error) {
+	var r struct {
+		Data domain.ResourceUpdate `json:"update"`
+	}
+	res, err := c.Get(ctx, fmt.Sprintf("/resource-updates/%d", id), nil, nil)
+	if err != nil {
+		return domain.ResourceUpdate{}, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.WithField("error", err).Error("")
+		}
+	}(res.Body)
+	if err := res.BindJSON(&r); err != nil {
+		return domain.ResourceUpdate{}, err
+	}
+	return r.Data, nil
+}
+
+// GetResourceAttachments returns the posts of a resource that have
+// attachments, starting with its description followed by its updates. The
+// update list does not always embed the attachments, in which case each post
+// is requested on its own.
+func (c *client) GetResourceAttachments(ctx context.Context, rid string, descriptionUpdateId int) ([]domain.ResourceUpdate, error) {
+	updates, err := c.GetResourceUpdates(ctx, rid)
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []domain.ResourceUpdate
+	if descriptionUpdateId != 0 {
+		posts = append(posts, domain.ResourceUpdate{ResourceUpdateId: descriptionUpdateId, AttachCount: 1})
+	}
+	for _, u := range updates {
+		if u.ResourceUpdateId != descriptionUpdateId && u.AttachCount > 0 {
+			posts = append(posts, u)
+		}
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(4)
+	for i := range posts {
+		i := i
+		if len(posts[i].Attachments) > 0 {
+			continue
+		}
+		g.Go(func() error {
+			u, err := c.GetResourceUpdate(ctx, posts[i].ResourceUpdateId)
+			if err != nil {
+				return err
+			}
+			posts[i] = u
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
 func (c *client) GetResourceVersions(ctx context.Context, rid string) ([]domain.ResourceVersion, error) {
 	var r struct {
 		Data []domain.ResourceVersion `json:"versions"`
@@ -167,8 +258,36 @@ func (c *client) GetResourceVersion(ctx context.Context, vid string) (domain.Res
 	return r.Data, nil
 }
 
-func (c *client) GetResourceCategory(ctx context.Context) (domain.ResourceCategory, error) {
-	return domain.ResourceCategory{}, nil
+// GetResourceFile will request the raw data of a resource file. Only the
+// range and conditional request headers are forwarded to the remote API.
+func (c *client) GetResourceFile(ctx context.Context, fid uint, headers http.Header) (*Response, error) {
+	h := q{}
+	for _, k := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+		if v := headers.Get(k); v != "" {
+			h[k] = v
+		}
+	}
+	return c.Stream(ctx, fmt.Sprintf("/attachments/%d/data", fid), h)
+}
+
+func (c *client) GetResourceCategory(ctx context.Context, cid string) (domain.ResourceCategory, error) {
+	var r struct {
+		Data domain.ResourceCategory `json:"category"`
+	}
+	res, err := c.Get(ctx, fmt.Sprintf("/resource-categories/%s", cid), nil, nil)
+	if err != nil {
+		return domain.ResourceCategory{}, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.WithField("error", err).Error("")
+		}
+	}(res.Body)
+	if err := res.BindJSON(&r); err != nil {
+		return domain.ResourceCategory{}, err
+	}
+	return r.Data, nil
 }
 
 func (c *client) getResourceReviewsPaged(ctx context.Context, page int, rid string) ([]domain.ResourceReview, Pagination, error) {
@@ -193,6 +312,28 @@ func (c *client) getResourceReviewsPaged(ctx context.Context, page int, rid stri
 	return r.Data, r.Meta, nil
 }
 
+func (c *client) getResourceUpdatesPaged(ctx context.Context, page int, rid string) ([]domain.ResourceUpdate, Pagination, error) {
+	var r struct {
+		Data []domain.ResourceUpdate `json:"updates"`
+		Meta Pagination              `json:"pagination"`
+	}
+
+	res, err := c.Get(ctx, fmt.Sprintf("/resources/%s/updates", rid), q{"page": strconv.Itoa(page)}, nil)
+	if err != nil {
+		return nil, r.Meta, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.WithField("error", err).Error("")
+		}
+	}(res.Body)
+	if err := res.BindJSON(&r); err != nil {
+		return nil, r.Meta, err
+	}
+	return r.Data, r.Meta, nil
+}
+
 func (c *client) getResourcesPaged(ctx context.Context, page int) ([]domain.Resource, Pagination, error) {
 	var r struct {
 		Data []domain.Resource `json:"resources"`
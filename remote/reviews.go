@@ -0,0 +1,87 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+import (
+	"carbon/domain"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/apex/log"
+)
+
+// The review endpoints act on behalf of whichever user is attached to the
+// context with WithUser, so XenForo applies its own permission checks.
+
+func (c *client) GetResourceReview(ctx context.Context, id uint) (domain.ResourceReview, error) {
+	res, err := c.Get(ctx, fmt.Sprintf("/resource-reviews/%d", id), nil, nil)
+	if err != nil {
+		return domain.ResourceReview{}, err
+	}
+	return bindReview(res)
+}
+
+func (c *client) CreateResourceReview(ctx context.Context, rid string, rating uint, message string) (domain.ResourceReview, error) {
+	res, err := c.Post(ctx, "/resource-reviews/", url.Values{
+		"resource_id": {rid},
+		"rating":      {strconv.FormatUint(uint64(rating), 10)},
+		"message":     {message},
+	}, nil)
+	if err != nil {
+		return domain.ResourceReview{}, err
+	}
+	return bindReview(res)
+}
+
+func (c *client) UpdateResourceReview(ctx context.Context, id uint, rating uint, message string) (domain.ResourceReview, error) {
+	res, err := c.Post(ctx, fmt.Sprintf("/resource-reviews/%d", id), url.Values{
+		"rating":  {strconv.FormatUint(uint64(rating), 10)},
+		"message": {message},
+	}, nil)
+	if err != nil {
+		return domain.ResourceReview{}, err
+	}
+	return bindReview(res)
+}
+
+func (c *client) DeleteResourceReview(ctx context.Context, id uint, reason string) error {
+	res, err := c.Delete(ctx, fmt.Sprintf("/resource-reviews/%d", id), url.Values{
+		"reason": {reason},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func bindReview(res *Response) (domain.ResourceReview, error) {
+	var r struct {
+		Data domain.ResourceReview `json:"review"`
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.WithField("error", err).Error("")
+		}
+	}(res.Body)
+	if err := res.BindJSON(&r); err != nil {
+		return domain.ResourceReview{}, err
+	}
+	return r.Data, nil
+}
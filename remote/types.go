@@ -21,7 +21,9 @@ type d map[string]interface{}
 
 type q map[string]string
 
-type TreeMap map[string]uint
+// TreeMap maps a parent category ID to the IDs of its direct children, as
+// returned by XenForo. Root categories are listed under "0".
+type TreeMap map[string][]uint
 
 type Pagination struct {
 	CurrentPage uint `json:"current_page"`
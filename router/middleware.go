@@ -16,16 +16,32 @@
 package router
 
 import (
+	"bytes"
+	"carbon/config"
 	"carbon/domain"
+	"carbon/internal/bbcode"
+	"carbon/internal/collection"
+	"carbon/internal/content"
+	"carbon/internal/media"
+	"carbon/internal/mirror"
+	"carbon/internal/notification"
+	"carbon/internal/related"
 	"carbon/internal/resource"
 	"carbon/internal/server"
+	"carbon/internal/signing"
+	"carbon/internal/stats"
 	"carbon/internal/token"
 	"carbon/internal/user"
 	"carbon/remote"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 )
 
@@ -35,7 +51,8 @@ func AttachCorsHeaders() gin.HandlerFunc {
 		c.Header("Access-Control-Allow-Credentials", "true")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Content-Encoding, Accept-Encoding, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Content-Encoding, Accept-Encoding, Authorization, If-None-Match, If-Modified-Since")
+		c.Header("Access-Control-Expose-Headers", "ETag, Last-Modified, X-Carbon-Key-Id, X-Carbon-Signature, X-Carbon-Signed-At")
 
 		// Around 2 hours, which is allowable by most browsers including Chromium.
 		// @see https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Access-Control-Max-Age#Directives
@@ -79,6 +96,62 @@ func AttachTokenManager(m *token.Manager) gin.HandlerFunc {
 	}
 }
 
+func AttachMirrorManager(m *mirror.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("mirror_manager", m)
+		c.Next()
+	}
+}
+
+func AttachContentManager(m *content.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("content_manager", m)
+		c.Next()
+	}
+}
+
+func AttachMediaManager(m *media.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("media_manager", m)
+		c.Next()
+	}
+}
+
+func AttachStatsManager(m *stats.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("stats_manager", m)
+		c.Next()
+	}
+}
+
+func AttachCollectionManager(m *collection.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("collection_manager", m)
+		c.Next()
+	}
+}
+
+func AttachRelatedManager(m *related.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("related_manager", m)
+		c.Next()
+	}
+}
+
+func AttachSigningManager(m *signing.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("signing_manager", m)
+		c.Next()
+	}
+}
+
+func AttachNotificationManager(m *notification.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("notification_manager", m)
+		c.Next()
+	}
+}
+
 // ExtractApiClient returns the remote API client instance and set it into the
 // gin.Context
 func ExtractApiClient(c *gin.Context) remote.Client {
@@ -97,6 +170,15 @@ func ExtractResourceManager(c *gin.Context) *resource.Manager {
 	panic("router/middleware: resource manager not present in context")
 }
 
+// ExtractUserManager returns the user manager instance and set it into the
+// gin.Context.
+func ExtractUserManager(c *gin.Context) *user.Manager {
+	if v, ok := c.Get("user_manager"); ok {
+		return v.(*user.Manager)
+	}
+	panic("router/middleware: user manager not present in context")
+}
+
 // ExtractServerManager returns the server manager instance and set it into the
 // gin.Context.
 func ExtractServerManager(c *gin.Context) *server.Manager {
@@ -113,6 +195,216 @@ func ExtractTokenManager(c *gin.Context) *token.Manager {
 	panic("router/middleware: token manager not presnet in context")
 }
 
+// ExtractMirrorManager returns the mirror manager instance and set it into the
+// gin.Context.
+func ExtractMirrorManager(c *gin.Context) *mirror.Manager {
+	if v, ok := c.Get("mirror_manager"); ok {
+		return v.(*mirror.Manager)
+	}
+	panic("router/middleware: mirror manager not present in context")
+}
+
+// ExtractContentManager returns the content manager instance and set it into
+// the gin.Context.
+func ExtractContentManager(c *gin.Context) *content.Manager {
+	if v, ok := c.Get("content_manager"); ok {
+		return v.(*content.Manager)
+	}
+	panic("router/middleware: content manager not present in context")
+}
+
+// ExtractFormat returns the format requested through the format query
+// parameter for the BBCode of posts. The request is aborted if the format is
+// not supported.
+func ExtractFormat(c *gin.Context) (bbcode.Format, bool) {
+	f, ok := bbcode.ParseFormat(c.Query("format"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The format must be one of bbcode, html, markdown or text.",
+		})
+	}
+	return f, ok
+}
+
+// ConditionalGet attaches a strong ETag and a Last-Modified header derived from
+// the generation of a cached collection, and answers conditional requests with
+// a 304 when the client already has the current representation. The path and
+// query string are part of the ETag as they change the representation, and so
+// is the authenticated user since they may see resources others cannot.
+func ConditionalGet(route string, source func(c *gin.Context) (uint64, time.Time)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		gen, modified := source(c)
+
+		var viewer string
+		if u := ExtractViewer(c); u != nil {
+			viewer = fmt.Sprintf("%d:%t", u.UserID, u.IsStaff)
+		}
+
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s:%d:%s?%s:%s", route, gen, c.Request.URL.Path, c.Request.URL.RawQuery, viewer)
+		etag := fmt.Sprintf(`"%x"`, h.Sum64())
+
+		policy, ok := config.Get().Api.CacheControl[route]
+		if !ok {
+			policy = "no-cache"
+		}
+		// Responses for a given user must not end up in a shared cache.
+		if viewer != "" {
+			policy = "private, no-cache"
+		}
+		c.Header("Vary", "Authorization")
+
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", policy)
+		if !modified.IsZero() {
+			c.Header("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		}
+
+		if isNotModified(c.Request, etag, modified) {
+			c.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+		c.Next()
+	}
+}
+
+// ExtractMediaManager returns the media manager instance and set it into the
+// gin.Context.
+func ExtractMediaManager(c *gin.Context) *media.Manager {
+	if v, ok := c.Get("media_manager"); ok {
+		return v.(*media.Manager)
+	}
+	panic("router/middleware: media manager not present in context")
+}
+
+// ExtractStatsManager returns the stats manager instance and set it into the
+// gin.Context.
+func ExtractStatsManager(c *gin.Context) *stats.Manager {
+	if v, ok := c.Get("stats_manager"); ok {
+		return v.(*stats.Manager)
+	}
+	panic("router/middleware: stats manager not present in context")
+}
+
+// ExtractCollectionManager returns the collection manager instance and set it
+// into the gin.Context.
+func ExtractCollectionManager(c *gin.Context) *collection.Manager {
+	if v, ok := c.Get("collection_manager"); ok {
+		return v.(*collection.Manager)
+	}
+	panic("router/middleware: collection manager not present in context")
+}
+
+// ExtractRelatedManager returns the related resources manager instance and
+// set it into the gin.Context.
+func ExtractRelatedManager(c *gin.Context) *related.Manager {
+	if v, ok := c.Get("related_manager"); ok {
+		return v.(*related.Manager)
+	}
+	panic("router/middleware: related manager not present in context")
+}
+
+// ExtractSigningManager returns the signing manager instance and set it into
+// the gin.Context.
+func ExtractSigningManager(c *gin.Context) *signing.Manager {
+	if v, ok := c.Get("signing_manager"); ok {
+		return v.(*signing.Manager)
+	}
+	panic("router/middleware: signing manager not present in context")
+}
+
+// ExtractNotificationManager returns the notification manager instance and
+// set it into the gin.Context.
+func ExtractNotificationManager(c *gin.Context) *notification.Manager {
+	if v, ok := c.Get("notification_manager"); ok {
+		return v.(*notification.Manager)
+	}
+	panic("router/middleware: notification manager not present in context")
+}
+
+// signingWriter holds back the response body so that it can be signed before
+// anything is sent.
+type signingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *signingWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *signingWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// SignResponse signs successful responses with the current signing key. The
+// signature covers the request path, the unix time of signing sent in
+// X-Carbon-Signed-At and the exact body bytes, before any content encoding,
+// joined by newlines, so that a signed response cannot be replayed for
+// another route or passed off as current forever. It is sent in the
+// X-Carbon-Signature header along with the id of the key in X-Carbon-Key-Id.
+// Responses are passed through as is when signing is disabled.
+func SignResponse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sm := ExtractSigningManager(c)
+		if !sm.Enabled() {
+			c.Next()
+			return
+		}
+
+		w := &signingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if w.body.Len() == 0 {
+			return
+		}
+		if status := c.Writer.Status(); status >= 200 && status < 300 {
+			signedAt := strconv.FormatInt(time.Now().Unix(), 10)
+			message := make([]byte, 0, len(c.Request.URL.Path)+len(signedAt)+w.body.Len()+2)
+			message = append(message, c.Request.URL.Path+"\n"+signedAt+"\n"...)
+			message = append(message, w.body.Bytes()...)
+
+			keyId, signature := sm.Sign(message)
+			c.Header("X-Carbon-Signed-At", signedAt)
+			c.Header("X-Carbon-Key-Id", keyId)
+			c.Header("X-Carbon-Signature", signature)
+		}
+		if _, err := c.Writer.Write(w.body.Bytes()); err != nil {
+			log.WithField("error", err).Debug("failed to write signed response")
+		}
+	}
+}
+
+// isNotModified evaluates the conditional request headers. If-None-Match takes
+// precedence over If-Modified-Since as per RFC 9110.
+func isNotModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+			if v == "*" || v == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !modified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
+
 // ResourceExists will ensure that the request resource exists in our cache.
 // Returns a 404 if we cannot locate it. If the resource is found it is set into
 // the request context.
@@ -125,6 +417,16 @@ func ResourceExists() gin.HandlerFunc {
 				return c.Param("resource") == r.ID()
 			})
 		}
+		if r != nil && !resource.Visible(r, ExtractViewer(c)) {
+			// Only hidden resources depend on who is asking, so the user is
+			// not looked up for anything else.
+			if ExtractViewer(c) == nil {
+				_ = authenticate(c)
+			}
+			if !resource.Visible(r, ExtractViewer(c)) {
+				r = nil
+			}
+		}
 		if r == nil {
 			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
 			return
@@ -188,50 +490,95 @@ func ExtractToken(c *gin.Context) domain.Token {
 	return v.(domain.Token)
 }
 
+var (
+	errNoToken      = errors.New("no bearer token present")
+	errInvalidToken = errors.New("invalid or expired token")
+)
+
+// authenticate resolves the user behind the bearer token of the request and
+// passes it further along the context.
+func authenticate(c *gin.Context) error {
+	token := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(token) != 2 || token[0] != "Bearer" {
+		return errNoToken
+	}
+
+	r, err := ExtractTokenManager(c).FindByToken(token[1])
+	if err != nil || time.Now().After(r.LoginTokenExpiresAt) {
+		return errInvalidToken
+	}
+
+	if c.ClientIP() != r.IPAddress {
+		return ErrIpMismatch
+	}
+
+	u, err := ExtractApiClient(c).GetUser(c, r.UserID)
+	if err != nil {
+		return err
+	}
+
+	// Pass up further along the context.
+	c.Set("user", u)
+	c.Set("token", r)
+	return nil
+}
+
 // RequireAuthorization will only check if the proper authentication heads
 // are present.
 func RequireAuthorization() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
-
-		if len(token) != 2 || token[0] != "Bearer" {
+		switch err := authenticate(c); {
+		case err == nil:
+			c.Next()
+		case errors.Is(err, errNoToken):
 			c.Header("WWW-Authenticate", "Bearer")
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
 				"error": "The required authorization heads were not present in the request.",
 			})
-
-			return
-		}
-
-		var r domain.Token
-		manager := ExtractTokenManager(c)
-		r, dbErr := manager.FindByToken(token[1])
-		if dbErr != nil || time.Now().After(r.LoginTokenExpiresAt) {
+		case errors.Is(err, errInvalidToken):
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
 				"error": "You are not authorized to access this endpoint.",
 			})
-
-			return
-		}
-
-		if c.ClientIP() != r.IPAddress {
-			NewError(ErrIpMismatch).Abort(c)
-			return
+		default:
+			NewError(err).Abort(c)
 		}
+	}
+}
 
-		var u domain.User
-		u, httpErr := ExtractApiClient(c).GetUser(c, r.UserID)
-		if httpErr != nil {
-			NewError(httpErr).Abort(c)
+// OptionalAuthorization authenticates the user if the request carries a
+// token, for public routes whose response depends on who is asking. Requests
+// with a token that is not valid are served as anonymous ones.
+func OptionalAuthorization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := authenticate(c)
+		if err != nil && !errors.Is(err, errNoToken) && !errors.Is(err, errInvalidToken) && !errors.Is(err, ErrIpMismatch) {
+			NewError(err).Abort(c)
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Pass up further along the context.
-		c.Set("user", u)
-		c.Set("token", r)
+// FindVisible returns the cached resource with the given ID, unless the user
+// making the request is not allowed to see it.
+func FindVisible(c *gin.Context, rid int) *domain.Resource {
+	r := ExtractResourceManager(c).Find(func(r *domain.Resource) bool {
+		return r.ResourceId == rid
+	})
+	if r == nil || !resource.Visible(r, ExtractViewer(c)) {
+		return nil
+	}
+	return r
+}
 
-		c.Next()
+// ExtractViewer returns the authenticated user, if any. Unlike ExtractUser it
+// can be used on routes where authentication is optional.
+func ExtractViewer(c *gin.Context) *domain.User {
+	if v, ok := c.Get("user"); ok {
+		u := v.(domain.User)
+		return &u
 	}
+	return nil
 }
 
 func ExtractAuthorization(c *gin.Context) string {
@@ -17,13 +17,22 @@ package router
 
 import (
 	"carbon/config"
+	"carbon/internal/collection"
+	"carbon/internal/content"
+	"carbon/internal/media"
+	"carbon/internal/mirror"
+	"carbon/internal/notification"
+	"carbon/internal/related"
 	"carbon/internal/resource"
 	"carbon/internal/server"
+	"carbon/internal/signing"
+	"carbon/internal/stats"
 	"carbon/internal/token"
 	"carbon/internal/user"
 	"carbon/remote"
 	"carbon/system"
 	"net/http"
+	"time"
 
 	_ "carbon/docs" // This imports the docs package created by Swag CLI
 
@@ -34,11 +43,23 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
+// trustedProxies are the addresses of the proxies whose forwarding headers are
+// honoured.
+var trustedProxies = []string{"127.0.0.1", "192.168.1.2", "10.0.0.0/8"}
+
 type ManagerGroup struct {
-	ResourceManager *resource.Manager
-	ServerManager   *server.Manager
-	UserManager     *user.Manager
-	TokenManager    *token.Manager
+	ResourceManager     *resource.Manager
+	ServerManager       *server.Manager
+	UserManager         *user.Manager
+	TokenManager        *token.Manager
+	MirrorManager       *mirror.Manager
+	ContentManager      *content.Manager
+	NotificationManager *notification.Manager
+	StatsManager        *stats.Manager
+	MediaManager        *media.Manager
+	CollectionManager   *collection.Manager
+	RelatedManager      *related.Manager
+	SigningManager      *signing.Manager
 }
 
 func NewClient(remote remote.Client, managers ManagerGroup) *gin.Engine {
@@ -48,15 +69,25 @@ func NewClient(remote remote.Client, managers ManagerGroup) *gin.Engine {
 	router := gin.New()
 
 	// If running behind an NGINX proxy.
-	router.SetTrustedProxies([]string{"127.0.0.1", "192.168.1.2", "10.0.0.0/8"})
+	router.SetTrustedProxies(trustedProxies)
 
 	router.Use(gin.Recovery())
 	router.Use(AttachApiClient(remote))
 	router.Use(AttachResourceManager(managers.ResourceManager),
 		AttachUserManager(managers.UserManager),
 		AttachServerManager(managers.ServerManager),
-		AttachTokenManager(managers.TokenManager))
-	router.Use(gzip.Gzip(gzip.DefaultCompression))
+		AttachTokenManager(managers.TokenManager),
+		AttachMirrorManager(managers.MirrorManager),
+		AttachContentManager(managers.ContentManager),
+		AttachNotificationManager(managers.NotificationManager),
+		AttachStatsManager(managers.StatsManager),
+		AttachMediaManager(managers.MediaManager),
+		AttachCollectionManager(managers.CollectionManager),
+		AttachRelatedManager(managers.RelatedManager),
+		AttachSigningManager(managers.SigningManager))
+	// Downloads are streamed as is so that range requests keep working, and
+	// images are already compressed.
+	router.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{"/downloads/", "/media/"})))
 	router.Use(gin.LoggerWithFormatter(func(params gin.LogFormatterParams) string {
 		log.WithFields(log.Fields{
 			"client_ip":   params.ClientIP,
@@ -74,7 +105,8 @@ func NewClient(remote remote.Client, managers ManagerGroup) *gin.Engine {
 
 	router.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
-			"version": system.Version,
+			"version":  system.Version,
+			"degraded": managers.ResourceManager.Degraded(),
 		})
 	})
 
@@ -88,6 +120,16 @@ func NewClient(remote remote.Client, managers ManagerGroup) *gin.Engine {
 	auth.POST("/refresh", postAuthRefresh)
 
 	router.GET("/users/me", RequireAuthorization(), getMe)
+	me := router.Group("/users/me")
+	me.Use(RequireAuthorization())
+	{
+		me.GET("/follows", getFollows)
+		me.POST("/follows/:resource", ResourceExists(), postFollow)
+		me.DELETE("/follows/:resource", deleteFollow)
+		me.GET("/notifications", getNotifications)
+		me.POST("/notifications/read", postNotificationsRead)
+		me.GET("/collections", getMyCollections)
+	}
 	router.GET("/users/:user", getUser)
 
 	router.GET("/servers", getAllServers)
@@ -102,14 +144,66 @@ func NewClient(remote remote.Client, managers ManagerGroup) *gin.Engine {
 		server.POST("/power", postServerPower)
 	}
 
-	router.GET("/resources", getAllResources)
-	router.GET("/resources/:resource", ResourceExists(), getResource)
-	router.GET("/resources/:resource/reviews", ResourceExists(), getResourceReviews)
-	router.GET("/resources/:resource/versions", ResourceExists(), getResourceVersions)
-	router.GET("/resources/:resource/updates", ResourceExists(), getResourceUpdates)
-	router.GET("/resource-categories", getAllCategories)
+	router.GET("/.well-known/carbon-keys.json", getSigningKeys)
+
+	router.GET("/resources", OptionalAuthorization(), SignResponse(), ConditionalGet("resources", func(c *gin.Context) (uint64, time.Time) {
+		return ExtractResourceManager(c).Generation()
+	}), getAllResources)
+	router.GET("/resources/changes", OptionalAuthorization(), SignResponse(), getResourceChanges)
+	router.GET("/resources/trending", OptionalAuthorization(), getTrendingResources)
+	router.GET("/resources/popular", OptionalAuthorization(), getPopularResources)
+	router.POST("/resources/batch", OptionalAuthorization(), postResourceBatch)
+	router.POST("/resources/check-updates", OptionalAuthorization(), SignResponse(), postCheckUpdates)
+	router.GET("/resources/:resource", OptionalAuthorization(), ResourceExists(), getResource)
+	router.GET("/resources/:resource/reviews", OptionalAuthorization(), ResourceExists(), getResourceReviews)
+	router.POST("/resources/:resource/reviews", RequireAuthorization(), ResourceExists(), postResourceReview)
+	router.PUT("/resources/:resource/reviews/:review", RequireAuthorization(), ResourceExists(), putResourceReview)
+	router.DELETE("/resources/:resource/reviews/:review", RequireAuthorization(), ResourceExists(), deleteResourceReview)
+	router.GET("/resources/:resource/versions", OptionalAuthorization(), ResourceExists(), getResourceVersions)
+	router.GET("/resources/:resource/related", OptionalAuthorization(), ResourceExists(), getRelatedResources)
+	router.GET("/resources/:resource/updates", OptionalAuthorization(), ResourceExists(), getResourceUpdates)
+	router.GET("/resources/:resource/gallery", OptionalAuthorization(), ResourceExists(), getResourceGallery)
+	router.GET("/resources/:resource/changelog", OptionalAuthorization(), ResourceExists(), getResourceChangelog)
+	router.GET("/resources/:resource/dependencies", OptionalAuthorization(), ResourceExists(), getResourceDependencies)
+	router.GET("/resources/:resource/files/:file/download", RequireAuthorization(), ResourceExists(), getResourceFileDownload)
+	router.GET("/downloads/:resource/:file", getDownload)
+	router.GET("/resource-categories", ConditionalGet("resource-categories", func(c *gin.Context) (uint64, time.Time) {
+		return ExtractResourceManager(c).CategoryGeneration()
+	}), getAllCategories)
 	router.GET("/resource-categories/:category", getCategory)
-	router.GET("/resource-versions/:version", getResourceVersion)
+	router.GET("/resource-versions/:version", OptionalAuthorization(), getResourceVersion)
+
+	router.GET("/collections", getPublicCollections)
+	router.POST("/collections", RequireAuthorization(), postCollection)
+	router.GET("/collections/:collection", OptionalAuthorization(), getCollection)
+	router.PUT("/collections/:collection", RequireAuthorization(), putCollection)
+	router.DELETE("/collections/:collection", RequireAuthorization(), deleteCollection)
+	router.GET("/collections/:collection/manifest", OptionalAuthorization(), SignResponse(), getCollectionManifest)
+
+	router.GET("/content/:guid", OptionalAuthorization(), getContent)
+
+	router.GET("/media/resources/:resource/icon", OptionalAuthorization(), ResourceExists(), getResourceIcon)
+	router.GET("/media/users/:user/avatar", getUserAvatar)
+
+	feeds := router.Group("/feeds")
+	{
+		feeds.GET("/resources.atom", ConditionalGet("feeds", func(c *gin.Context) (uint64, time.Time) {
+			return ExtractResourceManager(c).Generation()
+		}), getResourcesFeed)
+		feeds.GET("/categories/:category", ConditionalGet("feeds", func(c *gin.Context) (uint64, time.Time) {
+			rm := ExtractResourceManager(c)
+			gen, modified := rm.Generation()
+			cgen, cmodified := rm.CategoryGeneration()
+			if cmodified.After(modified) {
+				modified = cmodified
+			}
+			return gen ^ cgen, modified
+		}), getCategoryFeed)
+		feeds.GET("/resources/:resource/updates.atom", OptionalAuthorization(), ResourceExists(), ConditionalGet("feeds", func(c *gin.Context) (uint64, time.Time) {
+			r := ExtractResource(c)
+			return uint64(r.LastUpdate), time.Unix(int64(r.LastUpdate), 0)
+		}), getResourceUpdatesFeed)
+	}
 
 	return router
 }
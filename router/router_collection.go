@@ -0,0 +1,371 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package router
+
+import (
+	"carbon/domain"
+	"carbon/remote"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/apex/log"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxCollectionSize is the maximum number of resources in a collection.
+const maxCollectionSize = 200
+
+type CollectionRequest struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	Description string `json:"description" binding:"max=5000"`
+	Public      bool   `json:"public"`
+	// ResourceIds lists the resources of the collection in order.
+	ResourceIds []int `json:"resource_ids"`
+}
+
+// ShowAccount godoc
+// @Summary      Lists the public collections.
+// @Tags         collections
+// @Accept       json
+// @Produce      json
+// @Param        page      query     int  false  "Page number"
+// @Param        per_page  query     int  false  "Collections per page, up to 100"
+// @Success      200  {object}  []domain.Collection
+// @Failure      400  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /collections [get]
+func getPublicCollections(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The page must be a positive number."})
+		return
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", "20"))
+	if err != nil || perPage < 1 || perPage > 100 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The number of collections per page must be between 1 and 100."})
+		return
+	}
+
+	collections, total, err := ExtractCollectionManager(c).Public(c, (page-1)*perPage, perPage)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	for i := range collections {
+		attachCollectionResources(c, &collections[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"collections": collections,
+		"pagination": remote.Pagination{
+			CurrentPage: uint(page),
+			LastPage:    uint(max((total+int64(perPage)-1)/int64(perPage), 1)),
+			PerPage:     uint(perPage),
+			Shown:       uint(len(collections)),
+			Total:       uint(total),
+		},
+	})
+}
+
+// ShowAccount godoc
+// @Summary      Lists the collections of the authenticated user, public or not.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  []domain.Collection
+// @Failure      403  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /users/me/collections [get]
+func getMyCollections(c *gin.Context) {
+	collections, err := ExtractCollectionManager(c).ByUser(c, ExtractUser(c).UserID)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	for i := range collections {
+		attachCollectionResources(c, &collections[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"collections": collections,
+	})
+}
+
+// ShowAccount godoc
+// @Summary      Shows a collection.
+// @Description  Private collections can only be seen by their owner.
+// @Tags         collections
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  domain.Collection
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /collections/{collection} [get]
+func getCollection(c *gin.Context) {
+	col, ok := findCollection(c)
+	if !ok {
+		return
+	}
+	attachCollectionResources(c, &col)
+
+	c.JSON(http.StatusOK, gin.H{
+		"collection": col,
+	})
+}
+
+// ShowAccount godoc
+// @Summary      Creates a collection owned by the authenticated user.
+// @Tags         collections
+// @Accept       json
+// @Produce      json
+// @Param        request  body      CollectionRequest  true  "Collection"
+// @Success      201  {object}  domain.Collection
+// @Failure      400  {object}  RequestError
+// @Failure      403  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /collections [post]
+func postCollection(c *gin.Context) {
+	var req CollectionRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	items, ok := collectionItems(c, req.ResourceIds)
+	if !ok {
+		return
+	}
+
+	col := domain.Collection{
+		UserId:      ExtractUser(c).UserID,
+		Name:        req.Name,
+		Description: req.Description,
+		Public:      req.Public,
+		Items:       items,
+	}
+	if err := ExtractCollectionManager(c).Create(c, &col); err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	attachCollectionResources(c, &col)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"collection": col,
+	})
+}
+
+// ShowAccount godoc
+// @Summary      Updates a collection of the authenticated user.
+// @Description  The resources of the collection are replaced by the ones in the request.
+// @Tags         collections
+// @Accept       json
+// @Produce      json
+// @Param        request  body      CollectionRequest  true  "Collection"
+// @Success      200  {object}  domain.Collection
+// @Failure      400  {object}  RequestError
+// @Failure      403  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /collections/{collection} [put]
+func putCollection(c *gin.Context) {
+	var req CollectionRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	col, ok := ownCollection(c)
+	if !ok {
+		return
+	}
+	items, ok := collectionItems(c, req.ResourceIds)
+	if !ok {
+		return
+	}
+
+	col.Name = req.Name
+	col.Description = req.Description
+	col.Public = req.Public
+	col.Items = items
+	if err := ExtractCollectionManager(c).Update(c, &col); err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	attachCollectionResources(c, &col)
+
+	c.JSON(http.StatusOK, gin.H{
+		"collection": col,
+	})
+}
+
+// ShowAccount godoc
+// @Summary      Deletes a collection of the authenticated user.
+// @Tags         collections
+// @Accept       json
+// @Produce      json
+// @Success      204
+// @Failure      403  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /collections/{collection} [delete]
+func deleteCollection(c *gin.Context) {
+	col, ok := ownCollection(c)
+	if !ok {
+		return
+	}
+	if err := ExtractCollectionManager(c).Delete(c, col.ID); err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ShowAccount godoc
+// @Summary      Exports a collection as an install manifest.
+// @Description  The manifest lists the files of the latest version of every resource in the collection, in order. Resources that are no longer available or cannot be loaded right now are listed as missing.
+// @Tags         collections
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  domain.CollectionManifest
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /collections/{collection}/manifest [get]
+func getCollectionManifest(c *gin.Context) {
+	col, ok := findCollection(c)
+	if !ok {
+		return
+	}
+
+	entries := make([]*domain.ManifestEntry, len(col.Items))
+	g, ctx := errgroup.WithContext(c)
+	g.SetLimit(8)
+	for i, item := range col.Items {
+		i := i
+		r := FindVisible(c, item.ResourceId)
+		if r == nil {
+			continue
+		}
+		g.Go(func() error {
+			// A resource whose versions cannot be loaded right now is
+			// listed as missing rather than failing the whole manifest.
+			entry, err := latestManifest(ctx, c, r)
+			if err != nil {
+				log.WithFields(log.Fields{"resource": r.ResourceId, "error": err}).Warn("failed to load resource manifest")
+				return nil
+			}
+			entries[i] = &entry
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	manifest := domain.CollectionManifest{
+		CollectionId: col.ID,
+		Name:         col.Name,
+		Entries:      make([]domain.ManifestEntry, 0, len(entries)),
+		Missing:      []int{},
+	}
+	for i, e := range entries {
+		if e == nil {
+			manifest.Missing = append(manifest.Missing, col.Items[i].ResourceId)
+			continue
+		}
+		manifest.Entries = append(manifest.Entries, *e)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"manifest": manifest,
+	})
+}
+
+// findCollection looks up the collection in the path. Private collections
+// of other users are reported as not found so that their existence is not
+// given away.
+func findCollection(c *gin.Context) (domain.Collection, bool) {
+	id, err := strconv.ParseUint(c.Param("collection"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return domain.Collection{}, false
+	}
+
+	col, err := ExtractCollectionManager(c).Find(c, uint(id))
+	if err != nil {
+		NewError(err).Abort(c)
+		return domain.Collection{}, false
+	}
+	if viewer := ExtractViewer(c); !col.Public && (viewer == nil || viewer.UserID != col.UserId) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return domain.Collection{}, false
+	}
+	return col, true
+}
+
+// ownCollection looks up the collection in the path and makes sure that it
+// belongs to the authenticated user.
+func ownCollection(c *gin.Context) (domain.Collection, bool) {
+	col, ok := findCollection(c)
+	if !ok {
+		return domain.Collection{}, false
+	}
+	if col.UserId != ExtractUser(c).UserID {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "You can only change your own collections.",
+		})
+		return domain.Collection{}, false
+	}
+	return col, true
+}
+
+// collectionItems validates the resources of a collection request and turns
+// them into items, keeping their order.
+func collectionItems(c *gin.Context, ids []int) ([]domain.CollectionItem, bool) {
+	if len(ids) > maxCollectionSize {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("A collection cannot contain more than %d resources.", maxCollectionSize),
+		})
+		return nil, false
+	}
+
+	seen := make(map[int]bool, len(ids))
+	items := make([]domain.CollectionItem, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("The resource %d is listed more than once.", id),
+			})
+			return nil, false
+		}
+		seen[id] = true
+
+		if FindVisible(c, id) == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("The resource %d could not be found.", id),
+			})
+			return nil, false
+		}
+		items = append(items, domain.CollectionItem{ResourceId: id})
+	}
+	return items, true
+}
+
+// attachCollectionResources fills in the cached resources of the collection
+// items. Items whose resource the user cannot see are left without one.
+func attachCollectionResources(c *gin.Context, col *domain.Collection) {
+	if col.Items == nil {
+		col.Items = []domain.CollectionItem{}
+	}
+	for i := range col.Items {
+		col.Items[i].Resource = FindVisible(c, col.Items[i].ResourceId)
+	}
+}
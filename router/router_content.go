@@ -0,0 +1,63 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package router
+
+import (
+	"carbon/domain"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type contentMatch struct {
+	domain.ContentEntry
+	Resource *domain.Resource `json:"resource,omitempty"`
+}
+
+// ShowAccount godoc
+// @Summary      Finds the resources that provide a piece of content.
+// @Description  Looks up vehicles, loads, terrains and skins by GUID in the index built from resource archives.
+// @Tags         content
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  []domain.ContentEntry
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /content/{guid} [get]
+func getContent(c *gin.Context) {
+	entries, err := ExtractContentManager(c).Find(c.Param("guid"))
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	res := make([]contentMatch, 0, len(entries))
+	for _, e := range entries {
+		// Content of resources the user cannot see is left out entirely.
+		r := FindVisible(c, e.ResourceId)
+		if r == nil {
+			continue
+		}
+		res = append(res, contentMatch{ContentEntry: e, Resource: r})
+	}
+	if len(res) == 0 {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested content could not be found."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"content": res,
+	})
+}
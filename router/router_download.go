@@ -0,0 +1,246 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package router
+
+import (
+	"carbon/config"
+	"carbon/domain"
+	"carbon/internal/mirror"
+	"carbon/remote"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gin-gonic/gin"
+)
+
+// ShowAccount godoc
+// @Summary      Issues a short-lived download link for a resource file.
+// @Description  Checks that the authenticated user may download the resource and redirects to a signed URL served by carbon.
+// @Tags         resource
+// @Produce      json
+// @Success      302
+// @Failure      403  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/{resource}/files/{file}/download [get]
+func getResourceFileDownload(c *gin.Context) {
+	fid, err := strconv.ParseUint(c.Param("file"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested file could not be found."})
+		return
+	}
+
+	client := ExtractApiClient(c)
+	cache := ExtractResource(c)
+
+	// The cached resource reflects the permissions of the API key, so ask
+	// again on behalf of the user.
+	r, err := client.GetResource(remote.WithUser(c, ExtractUser(c).UserID), cache.ID())
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	if !r.CanDownload {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "You do not have permission to download this resource.",
+		})
+		return
+	}
+
+	ok, err := hasResourceFile(c, &r, uint(fid))
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested file could not be found."})
+		return
+	}
+
+	ttl := config.Get().Download.UrlTtl
+	if ttl <= 0 {
+		ttl = 300
+	}
+	expires := time.Now().Add(time.Second * ttl).Unix()
+
+	signature, ok := signDownload(r.ResourceId, uint(fid), expires)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Downloads are not available right now.",
+		})
+		return
+	}
+
+	u := url.URL{
+		Path: fmt.Sprintf("/downloads/%d/%d", r.ResourceId, fid),
+		RawQuery: url.Values{
+			"expires":   {strconv.FormatInt(expires, 10)},
+			"signature": {signature},
+		}.Encode(),
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, u.String())
+}
+
+// ShowAccount godoc
+// @Summary      Streams a resource file using a signed download link.
+// @Description  Supports range requests. The link must have been issued by the authenticated download endpoint and must not have expired.
+// @Tags         resource
+// @Produce      octet-stream
+// @Success      200
+// @Success      206
+// @Failure      403  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Router       /downloads/{resource}/{file} [get]
+func getDownload(c *gin.Context) {
+	rid, err := strconv.Atoi(c.Param("resource"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested file could not be found."})
+		return
+	}
+	fid, err := strconv.ParseUint(c.Param("file"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested file could not be found."})
+		return
+	}
+
+	// The signature already proves that the user was allowed to download
+	// the file when the link was issued, so the visibility of the resource
+	// is not checked again.
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || !verifyDownload(rid, uint(fid), expires, c.Query("signature")) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "The download link is invalid.",
+		})
+		return
+	}
+	if time.Now().Unix() > expires {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "The download link has expired.",
+		})
+		return
+	}
+
+	mm := ExtractMirrorManager(c)
+	if f, e, ok := mm.Open(uint(fid)); ok {
+		defer f.Close()
+		serveMirroredFile(c, f, e)
+		return
+	}
+
+	res, err := ExtractApiClient(c).GetResourceFile(c, uint(fid), c.Request.Header)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	defer res.Body.Close()
+
+	for _, k := range []string{"Content-Type", "Content-Length", "Content-Range", "Content-Disposition", "Accept-Ranges", "Last-Modified", "ETag"} {
+		if v := res.Header.Get(k); v != "" {
+			c.Header(k, v)
+		}
+	}
+
+	// Large files can take much longer than the server's write timeout.
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	// Only mirror files we know the name of, the others are from older
+	// versions which are rarely downloaded anyway.
+	r := ExtractResourceManager(c).Find(func(r *domain.Resource) bool {
+		return r.ResourceId == rid
+	})
+	if r != nil {
+		for _, f := range r.CurrentFiles {
+			if f.Id == uint(fid) {
+				mm.Schedule(f.Id, f.FileName)
+			}
+		}
+	}
+
+	c.Status(res.StatusCode)
+	if _, err := io.Copy(c.Writer, res.Body); err != nil {
+		log.WithFields(log.Fields{
+			"error":       err,
+			"resource_id": rid,
+			"file_id":     fid,
+		}).Debug("download stream interrupted")
+	}
+}
+
+// serveMirroredFile serves a file from the local mirror. Range and
+// conditional requests are handled by http.ServeContent using the content
+// hash as a strong ETag.
+func serveMirroredFile(c *gin.Context, f *os.File, e mirror.Entry) {
+	c.Header("ETag", `"`+e.Hash+`"`)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": e.FileName}))
+
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	http.ServeContent(c.Writer, c.Request, e.FileName, e.CreatedAt, f)
+}
+
+// hasResourceFile reports whether the file belongs to the resource, either as
+// one of its current files or as a file of an older version.
+func hasResourceFile(c *gin.Context, r *domain.Resource, fid uint) (bool, error) {
+	for _, f := range r.CurrentFiles {
+		if f.Id == fid {
+			return true, nil
+		}
+	}
+
+	versions, err := ExtractResourceManager(c).Versions(c, r)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range versions {
+		for _, f := range v.Files {
+			if f.Id == fid {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// MinSecretLength is the shortest secret download links are signed with. With
+// a shorter one, or none at all, links could be forged, so none are issued.
+const MinSecretLength = 32
+
+func signDownload(rid int, fid uint, expires int64) (string, bool) {
+	secret := config.Get().Secret
+	if len(secret) < MinSecretLength {
+		return "", false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "download:%d:%d:%d", rid, fid, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), true
+}
+
+func verifyDownload(rid int, fid uint, expires int64, signature string) bool {
+	expected, ok := signDownload(rid, fid, expires)
+	return ok && hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,291 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package router
+
+import (
+	"carbon/domain"
+	"carbon/internal/bbcode"
+	"carbon/internal/resource"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxFeedEntries is the number of entries served in a feed.
+const maxFeedEntries = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  *atomPerson `xml:"author,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Id         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Links      []atomLink     `xml:"link"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Categories []atomCategory `xml:"category,omitempty"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    *atomText      `xml:"content,omitempty"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// ShowAccount godoc
+// @Summary      Atom feed of the newest resources.
+// @Tags         feeds
+// @Produce      xml
+// @Success      200
+// @Router       /feeds/resources.atom [get]
+func getResourcesFeed(c *gin.Context) {
+	resources := feedResources(ExtractResourceManager(c).Collection(), nil)
+	serveFeed(c, "Resources", resourceEntries(c, resources))
+}
+
+// ShowAccount godoc
+// @Summary      Atom feed of the newest resources in a category.
+// @Description  Resources of the subcategories are included.
+// @Tags         feeds
+// @Produce      xml
+// @Success      200
+// @Failure      404  {object}  RequestError
+// @Router       /feeds/categories/{category}.atom [get]
+func getCategoryFeed(c *gin.Context) {
+	rm := ExtractResourceManager(c)
+
+	// Gin cannot match a parameter followed by a suffix in the same segment,
+	// so the extension is stripped here.
+	id, ok := strings.CutSuffix(c.Param("category"), ".atom")
+	cid, err := strconv.Atoi(id)
+	if !ok || err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return
+	}
+	category := rm.FindCategory(func(match *domain.ResourceCategory) bool {
+		return match.ResourceCategoryId == cid
+	})
+	if category == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return
+	}
+
+	// Collect the category and its subcategories.
+	ids := map[uint]bool{uint(cid): true}
+	for changed := true; changed; {
+		changed = false
+		for _, v := range rm.Categories() {
+			if ids[v.ParentCategoryId] && !ids[uint(v.ResourceCategoryId)] {
+				ids[uint(v.ResourceCategoryId)] = true
+				changed = true
+			}
+		}
+	}
+
+	resources := feedResources(rm.Collection(), func(r *domain.Resource) bool {
+		return ids[r.ResourceCategoryId]
+	})
+	serveFeed(c, category.Title, resourceEntries(c, resources))
+}
+
+// ShowAccount godoc
+// @Summary      Atom feed of the updates posted for a resource.
+// @Tags         feeds
+// @Produce      xml
+// @Success      200
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /feeds/resources/{resource}/updates.atom [get]
+func getResourceUpdatesFeed(c *gin.Context) {
+	r := ExtractResource(c)
+	updates, err := ExtractResourceManager(c).Updates(c, r)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+
+	entries := make([]atomEntry, 0, min(len(updates), maxFeedEntries))
+	for _, u := range updates {
+		if len(entries) == maxFeedEntries {
+			break
+		}
+		entries = append(entries, atomEntry{
+			Id:        entryId(c, u.ViewUrl, "resource-update", u.ResourceUpdateId),
+			Title:     u.Title,
+			Updated:   atomTime(u.PostDate),
+			Published: atomTime(u.PostDate),
+			Links:     []atomLink{{Href: u.ViewUrl, Rel: "alternate", Type: "text/html"}},
+			Author:    &atomPerson{Name: r.Username},
+			Content:   &atomText{Type: "html", Body: bbcode.Render(u.Message, bbcode.Html)},
+		})
+	}
+	serveFeed(c, r.Title+" updates", entries)
+}
+
+// feedResources returns the visible resources matching the filter, newest
+// first.
+func feedResources(all []*domain.Resource, filter func(r *domain.Resource) bool) []*domain.Resource {
+	var resources []*domain.Resource
+	for _, r := range all {
+		if r.ResourceState != resource.StateVisible || (filter != nil && !filter(r)) {
+			continue
+		}
+		resources = append(resources, r)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].ResourceDate > resources[j].ResourceDate
+	})
+	if len(resources) > maxFeedEntries {
+		resources = resources[:maxFeedEntries]
+	}
+	return resources
+}
+
+func resourceEntries(c *gin.Context, resources []*domain.Resource) []atomEntry {
+	rm := ExtractResourceManager(c)
+	entries := make([]atomEntry, 0, len(resources))
+	for _, r := range resources {
+		e := atomEntry{
+			Id:        entryId(c, r.ViewUrl, "resource", r.ResourceId),
+			Title:     r.Title,
+			Updated:   atomTime(r.LastUpdate),
+			Published: atomTime(r.ResourceDate),
+			Links:     []atomLink{{Href: r.ViewUrl, Rel: "alternate", Type: "text/html"}},
+			Author:    &atomPerson{Name: r.Username},
+			Summary:   &atomText{Type: "text", Body: r.TagLine},
+		}
+		cid := int(r.ResourceCategoryId)
+		if category := rm.FindCategory(func(match *domain.ResourceCategory) bool {
+			return match.ResourceCategoryId == cid
+		}); category != nil {
+			e.Categories = []atomCategory{{Term: category.ID(), Label: category.Title}}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// serveFeed writes the entries as an Atom feed. The feed is as recent as its
+// most recently updated entry, or as the resource cache if it has none.
+func serveFeed(c *gin.Context, title string, entries []atomEntry) {
+	_, modified := ExtractResourceManager(c).Generation()
+	self := feedUrl(c)
+	feed := atomFeed{
+		Id:      self,
+		Title:   title,
+		Updated: modified.UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Href: self, Rel: "self", Type: "application/atom+xml"}},
+		Author:  &atomPerson{Name: "carbon"},
+		Entries: entries,
+	}
+	if len(entries) > 0 {
+		feed.Updated = entries[0].Updated
+		for _, e := range entries {
+			if e.Updated > feed.Updated {
+				feed.Updated = e.Updated
+			}
+		}
+	}
+
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	c.Data(http.StatusOK, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), b...))
+}
+
+// feedUrl returns the absolute URL of the requested feed, which Atom uses to
+// identify it. The scheme forwarded by the proxy is only taken into account
+// when the request actually comes from a trusted one.
+func feedUrl(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if v := c.GetHeader("X-Forwarded-Proto"); (v == "http" || v == "https") && isTrustedProxy(c.RemoteIP()) {
+		scheme = v
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, c.Request.URL.Path)
+}
+
+// isTrustedProxy returns true if the address is one of the trusted proxies.
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, v := range trustedProxies {
+		if _, network, err := net.ParseCIDR(v); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if ip.Equal(net.ParseIP(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// entryId returns the URL of the entry, which Atom uses to identify it, or a
+// tag URI built from the kind and id of the entry when it has none.
+func entryId(c *gin.Context, url, kind string, id int) string {
+	if url != "" {
+		return url
+	}
+	host := c.Request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return fmt.Sprintf("tag:%s,2024:%s/%d", host, kind, id)
+}
+
+func atomTime(unix uint) string {
+	return time.Unix(int64(unix), 0).UTC().Format(time.RFC3339)
+}
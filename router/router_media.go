@@ -0,0 +1,132 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package router
+
+import (
+	"carbon/internal/media"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mediaCacheControl lets clients keep images for a day and serve them while
+// revalidating for a week, as icons and avatars rarely change.
+const mediaCacheControl = "public, max-age=86400, stale-while-revalidate=604800"
+
+// ShowAccount godoc
+// @Summary      Serves the icon of a resource.
+// @Description  The icon is resized to fit in a square of the given size. Without a size the original dimensions are kept.
+// @Tags         media
+// @Produce      png
+// @Produce      jpeg
+// @Param        size  query     int  false  "32, 48, 64, 96, 128, 256 or 512"
+// @Success      200
+// @Failure      400  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      502  {object}  RequestError
+// @Router       /media/resources/{resource}/icon [get]
+func getResourceIcon(c *gin.Context) {
+	serveMedia(c, ExtractResource(c).IconUrl)
+}
+
+// ShowAccount godoc
+// @Summary      Serves the avatar of a user.
+// @Description  The avatar is resized to fit in a square of the given size. Without a size the original dimensions are kept.
+// @Tags         media
+// @Produce      png
+// @Produce      jpeg
+// @Param        size  query     int  false  "32, 48, 64, 96, 128, 256 or 512"
+// @Success      200
+// @Failure      400  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      502  {object}  RequestError
+// @Router       /media/users/{user}/avatar [get]
+func getUserAvatar(c *gin.Context) {
+	uid, err := strconv.Atoi(c.Param("user"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return
+	}
+	u, err := ExtractUserManager(c).Profile(c, uid)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+
+	// XenForo lists the avatar in several sizes, the largest one is resized.
+	var src string
+	if urls, ok := u.AvatarUrls.(map[string]interface{}); ok {
+		for _, k := range []string{"o", "h", "l", "m", "s"} {
+			if v, ok := urls[k].(string); ok && v != "" {
+				src = v
+				break
+			}
+		}
+	}
+	serveMedia(c, src)
+}
+
+func serveMedia(c *gin.Context, src string) {
+	if src == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return
+	}
+
+	size := 0
+	if v := c.Query("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			n = -1
+		}
+		size = n
+	}
+
+	img, err := ExtractMediaManager(c).Get(c, src, size)
+	if err != nil {
+		switch {
+		case errors.Is(err, media.ErrInvalidSize):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The size must be one of 32, 48, 64, 96, 128, 256 or 512.",
+			})
+		case errors.Is(err, media.ErrHostNotAllowed):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		default:
+			c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+				"error": "The image could not be retrieved.",
+			})
+		}
+		return
+	}
+
+	f, err := os.Open(img.Path)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Type", img.ContentType)
+	c.Header("Cache-Control", mediaCacheControl)
+	// Variants are named after their source and size, which makes for a
+	// stable validator. The modification time is used to track access and
+	// is no use to clients.
+	c.Header("ETag", `"`+img.Hash+`"`)
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, f)
+}
@@ -1,31 +1,154 @@
 package router
 
 import (
+	"carbon/domain"
+	"carbon/internal/bbcode"
+	"carbon/internal/resource"
+	"carbon/internal/version"
+	"carbon/remote"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
 )
 
 // ShowAccount godoc
 // @Tags         resource
 // @Accept       json
 // @Produce      json
+// @Param        game_version  query     string  false  "Only return resources compatible with this game version"
+// @Param        state         query     string  false  "Only return resources in this state (visible, moderated or deleted)"
 // @Success      200  {object}  []domain.Resource
 // @Failure      400  {object}  RequestError
 // @Failure      404  {object}  RequestError
 // @Failure      500  {object}  RequestError
 // @Router       /resources/ [get]
 func getAllResources(c *gin.Context) {
+	viewer := ExtractViewer(c)
+
+	state := c.Query("state")
+	switch state {
+	case "", resource.StateVisible, resource.StateModerated, resource.StateDeleted:
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The state must be one of visible, moderated or deleted.",
+		})
+		return
+	}
+
+	collection := ExtractResourceManager(c).Collection()
+	resources := make([]*domain.Resource, 0, len(collection))
+	for _, r := range collection {
+		if resource.Visible(r, viewer) && (state == "" || r.ResourceState == state) {
+			resources = append(resources, r)
+		}
+	}
+
+	if v := c.Query("game_version"); v != "" {
+		game := version.Parse(v)
+		if !game.Valid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The game version must contain a version number.",
+			})
+			return
+		}
+
+		// Resources that do not say which versions they work with are
+		// kept, only those known to be incompatible are left out.
+		compatible := make([]*domain.Resource, 0, len(resources))
+		for _, r := range resources {
+			if resource.Compatible(r, game) {
+				compatible = append(compatible, r)
+			}
+		}
+		resources = compatible
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"resources": ExtractResourceManager(c).Collection(),
+		"resources": resources,
 	})
 }
 
 // ShowAccount godoc
+// @Summary      Lists the resources that changed since a cursor.
+// @Description  Without a cursor every resource is returned as added. The returned cursor must be passed on the next call. A 410 means the cursor is no longer valid and the client has to reload everything.
 // @Tags         resource
 // @Accept       json
 // @Produce      json
+// @Param        since  query     string  false  "Cursor returned by the previous call"
+// @Success      200  {object}  []domain.ResourceChange
+// @Failure      400  {object}  RequestError
+// @Failure      410  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/changes [get]
+func getResourceChanges(c *gin.Context) {
+	manager := ExtractResourceManager(c)
+
+	viewer := ExtractViewer(c)
+
+	if c.Query("since") == "" {
+		all, seq := manager.AllChanges()
+		changes := make([]domain.ResourceChange, 0, len(all))
+		for _, ch := range all {
+			if resource.Visible(ch.Resource, viewer) {
+				changes = append(changes, ch)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"changes": changes,
+			"cursor":  resource.EncodeCursor(seq),
+		})
+		return
+	}
+
+	since, err := resource.DecodeCursor(c.Query("since"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The since parameter is not a valid cursor.",
+		})
+		return
+	}
+
+	changes, seq, err := manager.ChangesSince(since)
+	if err != nil {
+		if errors.Is(err, resource.ErrCursorExpired) {
+			c.AbortWithStatusJSON(http.StatusGone, gin.H{
+				"error": "The cursor has expired, all resources must be reloaded.",
+			})
+			return
+		}
+		NewError(err).Abort(c)
+		return
+	}
+
+	// A resource that became hidden from the user looks like it was deleted.
+	for i, ch := range changes {
+		if ch.Resource != nil && !resource.Visible(ch.Resource, viewer) {
+			changes[i] = domain.ResourceChange{
+				Type:       domain.ResourceDeleted,
+				ResourceId: ch.ResourceId,
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"changes": changes,
+		"cursor":  resource.EncodeCursor(seq),
+	})
+}
+
+// ShowAccount godoc
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        format  query     string  false  "bbcode (default), html, markdown or text"
 // @Success      200  {object}  domain.Resource
 // @Failure      400  {object}  RequestError
 // @Failure      404  {object}  RequestError
@@ -35,41 +158,284 @@ func getResource(c *gin.Context) {
 	client := ExtractApiClient(c)
 	cache := ExtractResource(c)
 
-	resource, err := client.GetResource(c, cache.ID())
+	format, ok := ExtractFormat(c)
+	if !ok {
+		return
+	}
+
+	res, err := client.GetResource(c, cache.ID())
 	if err != nil {
 		NewError(err).Abort(c)
 		return
 	}
+	resource.SetDownloadUrls(res.ResourceId, res.CurrentFiles)
+	res.Description = bbcode.Render(res.Description, format)
 
 	// We can't extract from cache yet, we don't cache individual resources yet.
 	c.JSON(http.StatusOK, gin.H{
-		"resource": resource,
+		"resource": res,
 	})
 }
 
 // ShowAccount godoc
+// @Summary      Lists the reviews of a resource.
+// @Description  Reviews are paginated and sorted by date unless requested otherwise. Only visible reviews are listed, except to staff who also see deleted and moderated ones. The summary covers every visible review of the resource, whatever the rating filter.
 // @Tags         resource
 // @Accept       json
 // @Produce      json
+// @Param        page      query     int     false  "Page number"
+// @Param        per_page  query     int     false  "Reviews per page, up to 100"
+// @Param        sort      query     string  false  "date or rating"
+// @Param        order     query     string  false  "asc or desc"
+// @Param        rating    query     int     false  "Only reviews with this rating"
+// @Param        expand    query     string  false  "user to attach the reviewer's public profile"
+// @Param        format    query     string  false  "bbcode (default), html, markdown or text"
 // @Success      200  {object}  []domain.ResourceReview
 // @Failure      400  {object}  RequestError
 // @Failure      404  {object}  RequestError
 // @Failure      500  {object}  RequestError
 // @Router       /resources/{resource}/reviews [get]
 func getResourceReviews(c *gin.Context) {
-	client := ExtractApiClient(c)
 	r := ExtractResource(c)
 
-	reviews, err := client.GetResourceReviews(c, r.ID())
+	q, err := parseReviewQuery(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	format, ok := ExtractFormat(c)
+	if !ok {
+		return
+	}
+
+	all, err := ExtractResourceManager(c).Reviews(c, r)
 	if err != nil {
 		NewError(err).Abort(c)
 		return
 	}
+
+	// The cached slice is shared, so filter into a copy before sorting.
+	viewer := ExtractViewer(c)
+	staff := viewer != nil && viewer.IsStaff
+	reviews := make([]domain.ResourceReview, 0, len(all))
+	for _, v := range all {
+		if v.RatingState != "visible" && !staff {
+			continue
+		}
+		if q.rating == 0 || v.Rating == q.rating {
+			reviews = append(reviews, v)
+		}
+	}
+	sort.SliceStable(reviews, func(i, j int) bool {
+		a, b := reviews[i], reviews[j]
+		if q.sort == "rating" && a.Rating != b.Rating {
+			if q.ascending {
+				return a.Rating < b.Rating
+			}
+			return a.Rating > b.Rating
+		}
+		if q.ascending {
+			return a.RatingDate < b.RatingDate
+		}
+		return a.RatingDate > b.RatingDate
+	})
+
+	total := len(reviews)
+	lastPage := (total + q.perPage - 1) / q.perPage
+	if lastPage == 0 {
+		lastPage = 1
+	}
+	start := min((q.page-1)*q.perPage, total)
+	reviews = reviews[start:min(start+q.perPage, total)]
+	for i := range reviews {
+		reviews[i].Message = bbcode.Render(reviews[i].Message, format)
+	}
+
+	if q.expandUser {
+		if err := attachReviewers(c, reviews); err != nil {
+			NewError(err).Abort(c)
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"reviews": reviews,
+		"summary": resource.ReviewSummary(all),
+		"pagination": remote.Pagination{
+			CurrentPage: uint(q.page),
+			LastPage:    uint(lastPage),
+			PerPage:     uint(q.perPage),
+			Shown:       uint(len(reviews)),
+			Total:       uint(total),
+		},
+	})
+}
+
+type reviewQuery struct {
+	page       int
+	perPage    int
+	sort       string
+	ascending  bool
+	rating     uint
+	expandUser bool
+}
+
+func parseReviewQuery(c *gin.Context) (reviewQuery, error) {
+	q := reviewQuery{page: 1, perPage: 20, sort: "date"}
+
+	if v := c.Query("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return q, errors.New("The page must be a positive number.")
+		}
+		q.page = n
+	}
+	if v := c.Query("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return q, errors.New("The number of reviews per page must be between 1 and 100.")
+		}
+		q.perPage = n
+	}
+	switch v := c.DefaultQuery("sort", "date"); v {
+	case "date", "rating":
+		q.sort = v
+	default:
+		return q, errors.New("Reviews can only be sorted by date or rating.")
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		q.ascending = true
+	case "desc":
+	default:
+		return q, errors.New("The order must be either asc or desc.")
+	}
+	if v := c.Query("rating"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 5 {
+			return q, errors.New("The rating must be between 1 and 5.")
+		}
+		q.rating = uint(n)
+	}
+	for _, v := range strings.Split(c.Query("expand"), ",") {
+		if strings.TrimSpace(v) == "user" {
+			q.expandUser = true
+		}
+	}
+	return q, nil
+}
+
+// attachReviewers sets the public profile of each reviewer. Profiles are
+// cached by the user manager so a page of reviews only costs a request for
+// reviewers that have not been seen recently.
+func attachReviewers(c *gin.Context, reviews []domain.ResourceReview) error {
+	um := ExtractUserManager(c)
+
+	g, ctx := errgroup.WithContext(c)
+	g.SetLimit(8)
+	for i := range reviews {
+		i := i
+		if reviews[i].UserId == 0 {
+			continue
+		}
+		g.Go(func() error {
+			u, err := um.Profile(ctx, reviews[i].UserId)
+			if err != nil {
+				return err
+			}
+			reviews[i].User = &u
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// ShowAccount godoc
+// @Summary      Lists the screenshots of a resource.
+// @Description  Images attached to the description come first, followed by those of the updates.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  []domain.GalleryImage
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/{resource}/gallery [get]
+func getResourceGallery(c *gin.Context) {
+	images, err := ExtractResourceManager(c).Gallery(c, ExtractResource(c))
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"gallery": images,
+	})
+}
+
+// ShowAccount godoc
+// @Summary      Lists the versions and update posts of a resource in a single timeline.
+// @Description  Entries are sorted newest first. A version and the update post announcing it are merged into a release. With from, only what came after that version is returned.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        from    query     string  false  "Installed version"
+// @Param        format  query     string  false  "html (default), bbcode, markdown or text"
+// @Success      200  {object}  []domain.ChangelogEntry
+// @Failure      400  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/{resource}/changelog [get]
+func getResourceChangelog(c *gin.Context) {
+	format := bbcode.Html
+	if c.Query("format") != "" {
+		f, ok := ExtractFormat(c)
+		if !ok {
+			return
+		}
+		format = f
+	}
+
+	changelog, err := ExtractResourceManager(c).Changelog(c, ExtractResource(c))
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+
+	if from := c.Query("from"); from != "" {
+		changelog = changelog[:changelogCut(changelog, from)]
+	}
+	for i := range changelog {
+		changelog[i].Message = bbcode.Render(changelog[i].Message, format)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"changelog": changelog,
 	})
 }
 
+// changelogCut returns the index of the entry of the installed version, so
+// that everything before it is newer. Versions are matched exactly first, then
+// by comparing version numbers in case the installed version was never
+// published as such. Everything is returned if neither matches.
+func changelogCut(changelog []domain.ChangelogEntry, from string) int {
+	installed := version.Parse(from)
+	for i, e := range changelog {
+		if e.VersionString != "" && version.Parse(e.VersionString).String() == installed.String() {
+			return i
+		}
+	}
+	if !installed.Valid() {
+		return len(changelog)
+	}
+	for i, e := range changelog {
+		if v := version.Parse(e.VersionString); v.Valid() && v.Compare(installed) <= 0 {
+			return i
+		}
+	}
+	return len(changelog)
+}
+
 // ShowAccount godoc
 // @Tags         resource
 // @Accept       json
@@ -80,19 +446,66 @@ func getResourceReviews(c *gin.Context) {
 // @Failure      500  {object}  RequestError
 // @Router       /resources/{resource}/versions [get]
 func getResourceVersions(c *gin.Context) {
-	client := ExtractApiClient(c)
 	r := ExtractResource(c)
-
-	versions, err := client.GetResourceVersions(c, r.ID())
+	versions, err := ExtractResourceManager(c).Versions(c, r)
 	if err != nil {
 		NewError(err).Abort(c)
 		return
 	}
+
+	// Fetches of authenticated users feed the related resources.
+	if u := ExtractViewer(c); u != nil {
+		if err := ExtractRelatedManager(c).Record(c, u.UserID, r.ResourceId); err != nil {
+			log.WithFields(log.Fields{"resource": r.ResourceId, "error": err}).Warn("failed to record resource fetch")
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"versions": versions,
 	})
 }
 
+// ShowAccount godoc
+// @Summary      Resolves every resource the resource depends on.
+// @Description  Resources are returned in install order. Missing and deleted dependencies as well as dependency cycles are reported separately.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  domain.ResourceDependencies
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/{resource}/dependencies [get]
+func getResourceDependencies(c *gin.Context) {
+	deps := ExtractResourceManager(c).ResolveDependencies(ExtractResource(c))
+
+	// Dependencies the user cannot see are reported as missing, and the
+	// cycles going through them are left out.
+	viewer := ExtractViewer(c)
+	hidden := make(map[int]bool)
+	visible := deps.Resources[:0:0]
+	for _, r := range deps.Resources {
+		if resource.Visible(r, viewer) {
+			visible = append(visible, r)
+		} else {
+			hidden[r.ResourceId] = true
+			deps.Missing = append(deps.Missing, r.ResourceId)
+		}
+	}
+	deps.Resources = visible
+
+	cycles := deps.Cycles[:0:0]
+	for _, cycle := range deps.Cycles {
+		if !slices.ContainsFunc(cycle, func(id int) bool { return hidden[id] }) {
+			cycles = append(cycles, cycle)
+		}
+	}
+	deps.Cycles = cycles
+
+	c.JSON(http.StatusOK, gin.H{
+		"dependencies": deps,
+	})
+}
+
 // ShowAccount godoc
 // @Tags         resource
 // @Accept       json
@@ -103,38 +516,99 @@ func getResourceVersions(c *gin.Context) {
 // @Failure      500  {object}  RequestError
 // @Router       /resource-versions/{version} [get]
 func getResourceVersion(c *gin.Context) {
-	version, err := ExtractApiClient(c).GetResourceVersion(c, c.Param("version"))
+	vid, err := strconv.ParseUint(c.Param("version"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return
+	}
+
+	version, err := ExtractResourceManager(c).Version(c, uint(vid))
 	if err != nil {
 		NewError(err).Abort(c)
 		return
 	}
+	if FindVisible(c, int(version.ResourceId)) == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"version": version,
 	})
 }
 
+// ShowAccount godoc
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        since   query     int     false  "Only return updates posted after this unix timestamp"
+// @Param        format  query     string  false  "bbcode (default), html, markdown or text"
+// @Success      200  {object}  []domain.ResourceUpdate
+// @Failure      400  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/{resource}/updates [get]
 func getResourceUpdates(c *gin.Context) {
-	c.Status(http.StatusNotImplemented)
+	format, ok := ExtractFormat(c)
+	if !ok {
+		return
+	}
+
+	var since uint64
+	if v := c.Query("since"); v != "" {
+		s, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The since parameter must be a unix timestamp.",
+			})
+			return
+		}
+		since = s
+	}
+
+	updates, err := ExtractResourceManager(c).Updates(c, ExtractResource(c))
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+
+	// Updates are sorted newest first so we can stop at the first one that
+	// is too old.
+	res := make([]domain.ResourceUpdate, 0, len(updates))
+	for _, u := range updates {
+		if uint64(u.PostDate) <= since {
+			break
+		}
+		u.Message = bbcode.Render(u.Message, format)
+		res = append(res, u)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"updates": res,
+	})
 }
 
 // ShowAccount godoc
 // @Tags         resource
 // @Accept       json
 // @Produce      json
+// @Param        tree  query     bool  false  "Return the categories as a nested hierarchy"
 // @Success      200  {object}  []domain.ResourceCategory
 // @Failure      400  {object}  RequestError
 // @Failure      404  {object}  RequestError
 // @Failure      500  {object}  RequestError
 // @Router       /resource-categories/ [get]
 func getAllCategories(c *gin.Context) {
-	res, _, err := ExtractApiClient(c).GetResourceCategories(c)
-	if err != nil {
-		fmt.Println(err.Error())
-		NewError(err).Abort(c)
+	manager := ExtractResourceManager(c)
+
+	if tree, _ := strconv.ParseBool(c.Query("tree")); tree {
+		c.JSON(http.StatusOK, gin.H{
+			"categories": manager.CategoryTree(),
+		})
 		return
 	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"categories": res,
+		"categories": manager.Categories(),
 	})
 }
 
@@ -148,7 +622,23 @@ func getAllCategories(c *gin.Context) {
 // @Failure      500  {object}  RequestError
 // @Router       /resource-categories/{category} [get]
 func getCategory(c *gin.Context) {
-	res, err := ExtractApiClient(c).GetResourceCategory(c)
+	// Categories are refreshed alongside resources, so we only need to ask
+	// the remote API about categories created since the last refresh.
+	if res := ExtractResourceManager(c).FindCategory(func(r *domain.ResourceCategory) bool {
+		return c.Param("category") == r.ID()
+	}); res != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"category": res,
+		})
+		return
+	}
+
+	if _, err := strconv.Atoi(c.Param("category")); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return
+	}
+
+	res, err := ExtractApiClient(c).GetResourceCategory(c, c.Param("category"))
 	if err != nil {
 		NewError(err).Abort(c)
 		return
@@ -157,3 +647,89 @@ func getCategory(c *gin.Context) {
 		"category": res,
 	})
 }
+
+// maxBatchSize is the maximum number of resources and versions, combined,
+// that can be requested in a single batch.
+const maxBatchSize = 100
+
+type ResourceBatchRequest struct {
+	ResourceIds []int  `json:"resource_ids"`
+	VersionIds  []uint `json:"version_ids"`
+}
+
+type resourceBatchItem struct {
+	Id       int              `json:"id"`
+	Resource *domain.Resource `json:"resource,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+type versionBatchItem struct {
+	Id      uint                    `json:"id"`
+	Version *domain.ResourceVersion `json:"version,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+}
+
+// ShowAccount godoc
+// @Summary      Looks up several resources and versions at once.
+// @Description  Every requested ID gets an entry in the response, either with the cached data or with an error such as not_found.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        request  body      ResourceBatchRequest  true  "Resource and version IDs"
+// @Success      200  {object}  []domain.Resource
+// @Failure      400  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/batch [post]
+func postResourceBatch(c *gin.Context) {
+	var req ResourceBatchRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if len(req.ResourceIds)+len(req.VersionIds) > maxBatchSize {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("No more than %d resources and versions can be requested at once.", maxBatchSize),
+		})
+		return
+	}
+
+	manager := ExtractResourceManager(c)
+
+	resources := make([]resourceBatchItem, len(req.ResourceIds))
+	for i, id := range req.ResourceIds {
+		resources[i].Id = id
+		resources[i].Resource = FindVisible(c, id)
+		if resources[i].Resource == nil {
+			resources[i].Error = "not_found"
+		}
+	}
+
+	// Versions that are not cached yet have to come from the remote API, so
+	// look them up concurrently but without hammering it.
+	versions := make([]versionBatchItem, len(req.VersionIds))
+	g, ctx := errgroup.WithContext(c)
+	g.SetLimit(8)
+	for i, id := range req.VersionIds {
+		i, id := i, id
+		g.Go(func() error {
+			versions[i].Id = id
+			v, err := manager.Version(ctx, id)
+			switch {
+			case err == nil && FindVisible(c, int(v.ResourceId)) == nil:
+				versions[i].Error = "not_found"
+			case err == nil:
+				versions[i].Version = &v
+			case remote.IsRequestError(err) && remote.AsRequestError(err).StatusCode() == http.StatusNotFound:
+				versions[i].Error = "not_found"
+			default:
+				versions[i].Error = "unavailable"
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	c.JSON(http.StatusOK, gin.H{
+		"resources": resources,
+		"versions":  versions,
+	})
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package router
+
+import (
+	"carbon/domain"
+	"carbon/remote"
+	"net/http"
+	"strconv"
+
+	"github.com/apex/log"
+	"github.com/gin-gonic/gin"
+)
+
+type ReviewRequest struct {
+	Rating  uint   `json:"rating" binding:"required,min=1,max=5"`
+	Message string `json:"message" binding:"required"`
+}
+
+// ShowAccount godoc
+// @Summary      Reviews a resource as the authenticated user.
+// @Description  A user can only review a resource once. Use the update endpoint to change an existing review.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        request  body      ReviewRequest  true  "Review"
+// @Success      201  {object}  domain.ResourceReview
+// @Failure      400  {object}  RequestError
+// @Failure      403  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      409  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/{resource}/reviews [post]
+func postResourceReview(c *gin.Context) {
+	var req ReviewRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	r := ExtractResource(c)
+	u := ExtractUser(c)
+
+	reviews, err := ExtractResourceManager(c).Reviews(c, r)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	for _, v := range reviews {
+		if v.UserId == u.UserID && v.RatingState != "deleted" {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error":              "You have already reviewed this resource.",
+				"resource_rating_id": v.ResourceRatingId,
+			})
+			return
+		}
+	}
+
+	review, err := ExtractApiClient(c).CreateResourceReview(remote.WithUser(c, u.UserID), r.ID(), req.Rating, req.Message)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	reviewChanged(c, r)
+
+	review.User = nil
+	c.JSON(http.StatusCreated, gin.H{
+		"review": review,
+	})
+}
+
+// ShowAccount godoc
+// @Summary      Updates the authenticated user's review of a resource.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        request  body      ReviewRequest  true  "Review"
+// @Success      200  {object}  domain.ResourceReview
+// @Failure      400  {object}  RequestError
+// @Failure      403  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/{resource}/reviews/{review} [put]
+func putResourceReview(c *gin.Context) {
+	var req ReviewRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	existing, ok := ownReview(c)
+	if !ok {
+		return
+	}
+
+	r := ExtractResource(c)
+	ctx := remote.WithUser(c, ExtractUser(c).UserID)
+	review, err := ExtractApiClient(c).UpdateResourceReview(ctx, existing.ResourceRatingId, req.Rating, req.Message)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	reviewChanged(c, r)
+
+	review.User = nil
+	c.JSON(http.StatusOK, gin.H{
+		"review": review,
+	})
+}
+
+// ShowAccount godoc
+// @Summary      Deletes the authenticated user's review of a resource.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        reason  query     string  false  "Reason for the deletion"
+// @Success      204
+// @Failure      403  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/{resource}/reviews/{review} [delete]
+func deleteResourceReview(c *gin.Context) {
+	existing, ok := ownReview(c)
+	if !ok {
+		return
+	}
+
+	r := ExtractResource(c)
+	ctx := remote.WithUser(c, ExtractUser(c).UserID)
+	if err := ExtractApiClient(c).DeleteResourceReview(ctx, existing.ResourceRatingId, c.Query("reason")); err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	reviewChanged(c, r)
+
+	c.Status(http.StatusNoContent)
+}
+
+// ownReview looks up the review in the path and makes sure that it belongs
+// to the resource and to the authenticated user. The review is always read
+// from the remote API since the cached reviews may be out of date.
+func ownReview(c *gin.Context) (domain.ResourceReview, bool) {
+	id, err := strconv.ParseUint(c.Param("review"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return domain.ResourceReview{}, false
+	}
+
+	review, err := ExtractApiClient(c).GetResourceReview(c, uint(id))
+	if err != nil {
+		NewError(err).Abort(c)
+		return domain.ResourceReview{}, false
+	}
+	if int(review.ResourceId) != ExtractResource(c).ResourceId || review.RatingState == "deleted" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return domain.ResourceReview{}, false
+	}
+	if review.UserId != ExtractUser(c).UserID {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "You can only change your own review.",
+		})
+		return domain.ResourceReview{}, false
+	}
+	return review, true
+}
+
+// reviewChanged drops the cached reviews and reloads the resource so that its
+// rating and review count are up to date right away.
+func reviewChanged(c *gin.Context, r *domain.Resource) {
+	rm := ExtractResourceManager(c)
+	rm.InvalidateReviews(r.ResourceId)
+	if _, err := rm.Refresh(c, r.ResourceId); err != nil {
+		log.WithFields(log.Fields{"resource": r.ResourceId, "error": err}).Warn("failed to refresh resource after review change")
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShowAccount godoc
+// @Summary      Lists the public keys responses are signed with.
+// @Description  Signed responses carry the id of their key in X-Carbon-Key-Id, the unix time they were signed at in X-Carbon-Signed-At and a base64 encoded Ed25519 signature in X-Carbon-Signature. The signature covers the request path, the X-Carbon-Signed-At value and the body, joined by a newline each. Clients verify it against the public key with the matching id, and should reject responses signed for another path or too long ago. Retired keys no longer sign anything.
+// @Tags         signing
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  []domain.SigningKey
+// @Router       /.well-known/carbon-keys.json [get]
+func getSigningKeys(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, gin.H{
+		"keys": ExtractSigningManager(c).Keys(),
+	})
+}
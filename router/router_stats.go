@@ -0,0 +1,128 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package router
+
+import (
+	"carbon/domain"
+	"carbon/internal/resource"
+	"carbon/internal/stats"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShowAccount godoc
+// @Summary      Lists the resources gaining the most activity right now.
+// @Description  Downloads and, to a lesser extent, views gained within the window are weighted by how recent they are.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        window  query     string  false  "24h, 7d or 30d"
+// @Param        limit   query     int     false  "Number of resources, up to 100"
+// @Success      200  {object}  []domain.ResourceScore
+// @Failure      400  {object}  RequestError
+// @Router       /resources/trending [get]
+func getTrendingResources(c *gin.Context) {
+	serveRanking(c, "24h", ExtractStatsManager(c).Trending)
+}
+
+// ShowAccount godoc
+// @Summary      Lists the resources downloaded the most within a window.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        window  query     string  false  "24h, 7d or 30d"
+// @Param        limit   query     int     false  "Number of resources, up to 100"
+// @Success      200  {object}  []domain.ResourceScore
+// @Failure      400  {object}  RequestError
+// @Router       /resources/popular [get]
+func getPopularResources(c *gin.Context) {
+	serveRanking(c, "7d", ExtractStatsManager(c).Popular)
+}
+
+// ShowAccount godoc
+// @Summary      Lists resources related to a resource.
+// @Description  Resources downloaded by the same users come first, ordered by how often they go together. The list is filled up with the most downloaded resources of the same author and then of the same category.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        limit   query     int     false  "Number of resources, up to 50"
+// @Success      200  {object}  []domain.RelatedResource
+// @Failure      400  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Router       /resources/{resource}/related [get]
+func getRelatedResources(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 50 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The limit must be between 1 and 50.",
+		})
+		return
+	}
+
+	viewer := ExtractViewer(c)
+	res, computed := ExtractRelatedManager(c).Related(
+		ExtractResource(c),
+		ExtractResourceManager(c).Collection(),
+		func(r *domain.Resource) bool { return resource.Visible(r, viewer) },
+		limit,
+	)
+
+	c.JSON(http.StatusOK, gin.H{
+		"computed_at": computed,
+		"resources":   res,
+	})
+}
+
+func serveRanking(c *gin.Context, window string, ranking func(string) ([]domain.ResourceScore, time.Time)) {
+	window = c.DefaultQuery("window", window)
+	if _, ok := stats.Windows[window]; !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The window must be one of 24h, 7d or 30d.",
+		})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The limit must be between 1 and 100.",
+		})
+		return
+	}
+
+	scores, computed := ranking(window)
+
+	// Rankings are computed periodically, so resources that have been
+	// removed since are skipped.
+	res := make([]domain.ResourceScore, 0, limit)
+	for _, s := range scores {
+		if len(res) == limit {
+			break
+		}
+		s.Resource = FindVisible(c, s.ResourceId)
+		if s.Resource != nil {
+			res = append(res, s)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"window":      window,
+		"computed_at": computed,
+		"resources":   res,
+	})
+}
@@ -0,0 +1,220 @@
+// Copyright (C) 2024 Rafael Galvan
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package router
+
+import (
+	"carbon/domain"
+	"carbon/internal/version"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxUpdateCheckSize is the maximum number of installed resources that can be
+// checked in a single request.
+const maxUpdateCheckSize = 250
+
+const (
+	UpdateAvailable = "update_available"
+	UpToDate        = "up_to_date"
+	UpdateUnknown   = "unknown"
+	UpdateNotFound  = "not_found"
+)
+
+type InstalledResource struct {
+	ResourceId int    `json:"resource_id" binding:"required"`
+	Version    string `json:"version,omitempty"`
+	Sha256     string `json:"sha256,omitempty"`
+}
+
+type UpdateCheckRequest struct {
+	Installed []InstalledResource `json:"installed" binding:"required"`
+}
+
+type UpdateCheckResult struct {
+	ResourceId       int                   `json:"resource_id"`
+	Status           string                `json:"status"`
+	InstalledVersion string                `json:"installed_version,omitempty"`
+	LatestVersion    string                `json:"latest_version,omitempty"`
+	Manifest         *domain.ManifestEntry `json:"manifest,omitempty"`
+	Error            string                `json:"error,omitempty"`
+}
+
+// ShowAccount godoc
+// @Summary      Checks installed resources for updates.
+// @Description  Each installed resource is matched against its latest version, by file hash when the file has been mirrored and by version string otherwise. Resources with an update come with the manifest of the latest version, and resources that cannot be checked right now come with an error.
+// @Tags         resource
+// @Accept       json
+// @Produce      json
+// @Param        request  body      UpdateCheckRequest  true  "Installed resources"
+// @Success      200  {object}  []UpdateCheckResult
+// @Failure      400  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /resources/check-updates [post]
+func postCheckUpdates(c *gin.Context) {
+	var req UpdateCheckRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if len(req.Installed) > maxUpdateCheckSize {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("No more than %d resources can be checked at once.", maxUpdateCheckSize),
+		})
+		return
+	}
+
+	results := make([]UpdateCheckResult, len(req.Installed))
+
+	g, ctx := errgroup.WithContext(c)
+	g.SetLimit(8)
+	for i, installed := range req.Installed {
+		i, installed := i, installed
+		results[i] = UpdateCheckResult{
+			ResourceId:       installed.ResourceId,
+			Status:           UpdateNotFound,
+			InstalledVersion: installed.Version,
+		}
+
+		r := FindVisible(c, installed.ResourceId)
+		if r == nil {
+			continue
+		}
+
+		g.Go(func() error {
+			entry, err := latestManifest(ctx, c, r)
+			var older map[string]bool
+			if err == nil {
+				older, err = olderHashes(ctx, c, r)
+			}
+			// A resource whose versions cannot be loaded right now does not
+			// fail the check of all the others.
+			if err != nil {
+				log.WithFields(log.Fields{"resource": r.ResourceId, "error": err}).Warn("failed to check resource for updates")
+				results[i].Status = UpdateUnknown
+				results[i].Error = "unavailable"
+				return nil
+			}
+
+			results[i].LatestVersion = entry.Version
+			results[i].Status = updateStatus(installed, entry, older)
+			if results[i].Status != UpToDate {
+				results[i].Manifest = &entry
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+	})
+}
+
+// updateStatus decides whether the installed copy is the latest one. A file
+// hash is the most reliable, but we only know the hashes of mirrored files,
+// so the version strings are compared when the hash is not one we know of.
+// Older holds the hashes of the mirrored files of previous versions.
+func updateStatus(installed InstalledResource, latest domain.ManifestEntry, older map[string]bool) string {
+	if installed.Sha256 != "" {
+		for _, f := range latest.Files {
+			if f.Sha256 != "" && strings.EqualFold(f.Sha256, installed.Sha256) {
+				return UpToDate
+			}
+		}
+		if older[strings.ToLower(installed.Sha256)] {
+			return UpdateAvailable
+		}
+	}
+
+	if installed.Version == "" {
+		return UpdateUnknown
+	}
+
+	a, b := version.Parse(installed.Version), version.Parse(latest.Version)
+	if !a.Valid() || !b.Valid() {
+		if a.String() == b.String() {
+			return UpToDate
+		}
+		return UpdateUnknown
+	}
+	if a.Compare(b) < 0 {
+		return UpdateAvailable
+	}
+	return UpToDate
+}
+
+// latestManifest returns the manifest of the latest version of the resource.
+func latestManifest(ctx context.Context, c *gin.Context, r *domain.Resource) (domain.ManifestEntry, error) {
+	versions, err := ExtractResourceManager(c).Versions(ctx, r)
+	if err != nil {
+		return domain.ManifestEntry{}, err
+	}
+
+	// Versions are sorted newest first. Resources without any version
+	// listed fall back to their current files.
+	entry := domain.ManifestEntry{
+		ResourceId: r.ResourceId,
+		Title:      r.Title,
+		Version:    r.Version,
+		Files:      manifestFiles(c, r.CurrentFiles),
+	}
+	if len(versions) > 0 {
+		entry.ResourceVersionId = versions[0].ResourceVersionId
+		entry.Version = versions[0].VersionString
+		entry.Files = manifestFiles(c, versions[0].Files)
+	}
+	return entry, nil
+}
+
+// olderHashes returns the hashes of the mirrored files of every version of
+// the resource but the latest one.
+func olderHashes(ctx context.Context, c *gin.Context, r *domain.Resource) (map[string]bool, error) {
+	versions, err := ExtractResourceManager(c).Versions(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	mm := ExtractMirrorManager(c)
+	hashes := make(map[string]bool)
+	for i := 1; i < len(versions); i++ {
+		for _, f := range versions[i].Files {
+			if e, ok := mm.Lookup(f.Id); ok {
+				hashes[strings.ToLower(e.Hash)] = true
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// manifestFiles turns resource files into manifest files, filling in the
+// hashes of those that have been mirrored.
+func manifestFiles(c *gin.Context, files []domain.ResourceFile) []domain.ManifestFile {
+	mm := ExtractMirrorManager(c)
+	res := make([]domain.ManifestFile, 0, len(files))
+	for _, f := range files {
+		mf := domain.ManifestFile{ResourceFile: f}
+		if e, ok := mm.Lookup(f.Id); ok {
+			mf.Sha256 = e.Hash
+		}
+		res = append(res, mf)
+	}
+	return res
+}
@@ -16,7 +16,9 @@
 package router
 
 import (
+	"carbon/remote"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -48,3 +50,158 @@ func getMe(c *gin.Context) {
 		"me": ExtractUser(c),
 	})
 }
+
+// ShowAccount godoc
+// @Summary      Lists the resources the authenticated user follows.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  []domain.Follow
+// @Failure      403  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /users/me/follows [get]
+func getFollows(c *gin.Context) {
+	follows, err := ExtractNotificationManager(c).Follows(ExtractUser(c).UserID)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+
+	for i := range follows {
+		follows[i].Resource = FindVisible(c, follows[i].ResourceId)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"follows": follows,
+	})
+}
+
+// ShowAccount godoc
+// @Summary      Follows a resource.
+// @Description  The authenticated user is notified whenever a new version of the resource is released.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Success      201  {object}  domain.Follow
+// @Failure      403  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /users/me/follows/{resource} [post]
+func postFollow(c *gin.Context) {
+	f, err := ExtractNotificationManager(c).Follow(ExtractUser(c).UserID, ExtractResource(c).ResourceId)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"follow": f,
+	})
+}
+
+// ShowAccount godoc
+// @Summary      Stops following a resource.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Success      204
+// @Failure      403  {object}  RequestError
+// @Failure      404  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /users/me/follows/{resource} [delete]
+func deleteFollow(c *gin.Context) {
+	// Deleted resources can still be unfollowed, so the resource is not
+	// looked up in the cache.
+	rid, err := strconv.Atoi(c.Param("resource"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource could not be found."})
+		return
+	}
+	if err := ExtractNotificationManager(c).Unfollow(ExtractUser(c).UserID, rid); err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ShowAccount godoc
+// @Summary      Lists the notifications of the authenticated user.
+// @Description  Unread notifications are folded per resource, so the unread count is the number of followed resources updated since the user last marked them as read.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        unread    query     bool  false  "Only unread notifications"
+// @Param        page      query     int   false  "Page number"
+// @Param        per_page  query     int   false  "Notifications per page, up to 100"
+// @Success      200  {object}  []domain.Notification
+// @Failure      400  {object}  RequestError
+// @Failure      403  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /users/me/notifications [get]
+func getNotifications(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The page must be a positive number."})
+		return
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", "20"))
+	if err != nil || perPage < 1 || perPage > 100 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The number of notifications per page must be between 1 and 100."})
+		return
+	}
+	unreadOnly, _ := strconv.ParseBool(c.Query("unread"))
+
+	uid := ExtractUser(c).UserID
+	nm := ExtractNotificationManager(c)
+	notifications, total, err := nm.Notifications(uid, unreadOnly, (page-1)*perPage, perPage)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	unread, err := nm.UnreadCount(uid)
+	if err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": notifications,
+		"unread":        unread,
+		"pagination": remote.Pagination{
+			CurrentPage: uint(page),
+			LastPage:    uint(max((total+int64(perPage)-1)/int64(perPage), 1)),
+			PerPage:     uint(perPage),
+			Shown:       uint(len(notifications)),
+			Total:       uint(total),
+		},
+	})
+}
+
+type NotificationReadRequest struct {
+	NotificationIds []uint `json:"notification_ids"`
+}
+
+// ShowAccount godoc
+// @Summary      Marks notifications as read.
+// @Description  Every unread notification is marked as read when no IDs are given.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request  body      NotificationReadRequest  false  "Notifications to mark as read"
+// @Success      204
+// @Failure      400  {object}  RequestError
+// @Failure      403  {object}  RequestError
+// @Failure      500  {object}  RequestError
+// @Router       /users/me/notifications/read [post]
+func postNotificationsRead(c *gin.Context) {
+	var req NotificationReadRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&req); err != nil {
+			return
+		}
+	}
+	if err := ExtractNotificationManager(c).MarkRead(ExtractUser(c).UserID, req.NotificationIds); err != nil {
+		NewError(err).Abort(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}